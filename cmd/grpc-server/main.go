@@ -4,26 +4,55 @@ import (
 	"log"
 	"net"
 
-	pb "messaging-service/messaging-service/gen/messagepb"
-
+	"messaging-service/internal/config"
+	"messaging-service/internal/db"
 	grpc_server "messaging-service/internal/grpc"
-
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/reflection"
+	"messaging-service/internal/redis"
+	"messaging-service/internal/storage"
+	ws "messaging-service/internal/websocket"
 )
 
+// Standalone gRPC server. cmd/server can serve the same API in-process
+// instead (GRPC_LISTEN), sharing its Hub with the WebSocket clients.
 func main() {
+	// load config
+	cfg := config.LoadConfig()
+
+	// init db; the schema is applied by the HTTP server
+	if err := db.Init(cfg); err != nil {
+		log.Fatal(err)
+	}
+
+	// init redis
+	redis.Init(cfg)
+
+	// attachment URLs are signed with the shared key; the HTTP server serves
+	// them, so a key of our own would make every URL fail
+	if cfg.AttachmentSigningKey == "" {
+		log.Fatal("ATTACHMENT_SIGNING_KEY is required, with the same value as the HTTP server")
+	}
+	if err := storage.Init(cfg); err != nil {
+		log.Fatal("Blob store init failed:", err)
+	}
+
+	// deliveries reach WebSocket clients of the HTTP instances through the
+	// cluster bus
+	hub := ws.NewHub()
+	hub.EnableClusterBus(cfg.NodeID)
+	hub.EnableEventLog()
+
 	lis, err := net.Listen("tcp", "0.0.0.0:50051")
 	if err != nil {
 		log.Fatalf("failed to listen: %v", err)
 	}
 
-	grpcServer := grpc.NewServer()
-	pb.RegisterMessageServiceServer(grpcServer, &grpc_server.MessageServer{})
-
-	reflection.Register(grpcServer)
+	// every RPC except health checks needs a user or service credential
+	grpcServer, err := grpc_server.NewServer(cfg, hub)
+	if err != nil {
+		log.Fatal("gRPC server init failed:", err)
+	}
 
-	log.Println("🚀 gRPC Health service running on :50051")
+	log.Println("🚀 gRPC MessageService running on :50051")
 	if err := grpcServer.Serve(lis); err != nil {
 		log.Fatalf("failed to serve: %v", err)
 	}
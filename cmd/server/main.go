@@ -3,13 +3,17 @@ package main
 import (
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 
 	"messaging-service/internal/api"
 	"messaging-service/internal/auth"
 	"messaging-service/internal/config"
 	"messaging-service/internal/db"
+	grpc_server "messaging-service/internal/grpc"
+	"messaging-service/internal/model"
 	"messaging-service/internal/redis"
+	"messaging-service/internal/storage"
 	ws "messaging-service/internal/websocket" // alias the internal package
 
 	"github.com/gorilla/websocket" // alias Gorilla WebSocket
@@ -32,12 +36,57 @@ func main() {
 		log.Fatal(err)
 	}
 
+	// apply schema (conversations, members, ...)
+	if err := db.Migrate(); err != nil {
+		log.Fatal("Migration failed:", err)
+	}
+
+	// message policies
+	model.DeleteForEveryoneWindow = cfg.DeleteForEveryoneWindow
+	model.MaxAttachmentSize = cfg.MaxAttachmentSize
+
+	// attachment blob store; outside development several instances (and the
+	// standalone gRPC server) must verify each other's signed URLs
+	if cfg.AttachmentSigningKey == "" && cfg.AppEnv != "development" {
+		log.Fatal("ATTACHMENT_SIGNING_KEY is required outside development")
+	}
+	if err := storage.Init(cfg); err != nil {
+		log.Fatal("Blob store init failed:", err)
+	}
+
 	// init redis
 	redis.Init(cfg)
 
+	// service tokens are for the gRPC API only
+	auth.ServiceAudience = cfg.GRPCServiceAudience
+
+	// websocket keepalive and frame limits
+	ws.Limits = ws.ConnectionLimits{
+		PingInterval:   cfg.WSPingInterval,
+		PongWait:       cfg.WSPongWait,
+		WriteWait:      cfg.WSWriteWait,
+		MaxMessageSize: cfg.WSMaxMessageSize,
+	}
+	switch cfg.WSOverflowPolicy {
+	case ws.OverflowDisconnect, ws.OverflowSpill:
+		ws.Overflow = cfg.WSOverflowPolicy
+	default:
+		log.Printf("unknown WS_OVERFLOW_POLICY %q, using %s", cfg.WSOverflowPolicy, ws.Overflow)
+	}
+
 	// create hub once
 	hub := ws.NewHub()
 
+	// fan out deliveries to the other instances through Redis Pub/Sub
+	hub.EnableClusterBus(cfg.NodeID)
+	log.Printf("Cluster bus enabled (node %s)", cfg.NodeID)
+
+	// record pushed events for gRPC Subscribe streams
+	hub.EnableEventLog()
+
+	// keep this instance's connections online in Redis with heartbeats
+	hub.StartPresence(cfg.PresenceIdleAfter)
+
 	// websocket handler
 	http.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
 		wsHandler(w, r, hub)
@@ -46,8 +95,43 @@ func main() {
 	// register REST API routes (pass hub)
 	api.RegisterRoutes(hub)
 
+	srv := &http.Server{Addr: ":8080"}
+
+	// optionally serve the gRPC API from this process, sharing the hub, so
+	// its messages reach local WebSocket clients directly
+	if cfg.GRPCListen != "" {
+		grpcServer, err := grpc_server.NewServer(cfg, hub)
+		if err != nil {
+			log.Fatal("gRPC server init failed:", err)
+		}
+
+		if cfg.GRPCListen == grpc_server.ListenShared {
+			if cfg.GRPCTLSCert != "" {
+				log.Fatal("GRPC_TLS_CERT cannot be used with GRPC_LISTEN=shared")
+			}
+			// gRPC clients speak HTTP/2 without TLS (h2c) on the HTTP port
+			protocols := new(http.Protocols)
+			protocols.SetHTTP1(true)
+			protocols.SetUnencryptedHTTP2(true)
+			srv.Protocols = protocols
+			srv.Handler = grpc_server.SharedHandler(grpcServer, http.DefaultServeMux)
+			log.Println("gRPC MessageService sharing :8080")
+		} else {
+			lis, err := net.Listen("tcp", cfg.GRPCListen)
+			if err != nil {
+				log.Fatalf("failed to listen: %v", err)
+			}
+			go func() {
+				if err := grpcServer.Serve(lis); err != nil {
+					log.Fatalf("gRPC server failed: %v", err)
+				}
+			}()
+			log.Printf("gRPC MessageService running on %s", cfg.GRPCListen)
+		}
+	}
+
 	log.Println("Server started on :8080")
-	if err := http.ListenAndServe(":8080", nil); err != nil {
+	if err := srv.ListenAndServe(); err != nil {
 		log.Fatal("Server failed to start:", err)
 	}
 }
@@ -71,7 +155,7 @@ func wsHandler(w http.ResponseWriter, r *http.Request, hub *ws.Hub) {
 	}
 
 	// Verify JWT token
-	claims, err := auth.VerifyToken(tokenStr, pubKey)
+	claims, err := auth.VerifyUserToken(tokenStr, pubKey)
 	if err != nil {
 		log.Println("Token verification failed:", err)
 		http.Error(w, "Unauthorized: "+err.Error(), http.StatusUnauthorized)
@@ -105,5 +189,5 @@ func wsHandler(w http.ResponseWriter, r *http.Request, hub *ws.Hub) {
 	hub.Register(userID, conn, userData)
 
 	fmt.Printf("Registering authenticated connection for user: %s (role: %s)\n", userID, claims.Role)
-	go conn.ReadPump(hub, userID)
+	go conn.ReadPump(hub)
 }
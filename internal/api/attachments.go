@@ -0,0 +1,275 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"messaging-service/internal/auth"
+	"messaging-service/internal/media"
+	"messaging-service/internal/model"
+	"messaging-service/internal/storage"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// multipartOverhead leaves room for the multipart headers around the file
+const multipartOverhead = 1 << 20
+
+// uploadAttachmentHandler streams a multipart "file" field into the blob store
+// and records it as an attachment of the conversation in conversation_id.
+// The returned ID is then sent with a message in attachment_ids.
+func uploadAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := auth.GetUserIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Authentication error", http.StatusUnauthorized)
+		return
+	}
+
+	conversationID, err := strconv.Atoi(r.URL.Query().Get("conversation_id"))
+	if err != nil {
+		http.Error(w, "conversation_id query param required", http.StatusBadRequest)
+		return
+	}
+	isMember, err := model.IsConversationMember(conversationID, userID)
+	if err != nil {
+		http.Error(w, "Error checking membership", http.StatusInternalServerError)
+		return
+	}
+	if !isMember {
+		http.Error(w, "Forbidden: not a member of this conversation", http.StatusForbidden)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, model.MaxAttachmentSize+multipartOverhead)
+	reader, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, "multipart/form-data body required", http.StatusBadRequest)
+		return
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			http.Error(w, "file field required", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Invalid multipart body", http.StatusBadRequest)
+			return
+		}
+		if part.FormName() == "file" {
+			storeUpload(w, r, part, conversationID, userID)
+			return
+		}
+	}
+}
+
+// storeUpload sniffs, stores and records one uploaded file
+func storeUpload(w http.ResponseWriter, r *http.Request, file io.Reader, conversationID int, userID string) {
+	// The declared content type is not trusted; sniff it from the first bytes
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		http.Error(w, "Error reading upload", http.StatusBadRequest)
+		return
+	}
+	head = head[:n]
+	contentType, _, _ := mime.ParseMediaType(http.DetectContentType(head))
+	if !model.IsAllowedAttachmentType(contentType) {
+		http.Error(w, "Unsupported file type "+contentType, http.StatusUnsupportedMediaType)
+		return
+	}
+
+	a := model.Attachment{
+		ConversationID: conversationID,
+		UploaderID:     userID,
+		Filename:       uploadFilename(file),
+		ContentType:    contentType,
+		StorageKey:     storage.NewKey(),
+	}
+
+	// Read one byte past the limit to tell a full-size file from an oversized one
+	body := io.MultiReader(bytes.NewReader(head), io.LimitReader(file, model.MaxAttachmentSize+1-int64(n)))
+	a.Size, err = storage.Store.Put(r.Context(), a.StorageKey, body, contentType)
+	if err != nil {
+		log.Println("error storing upload:", err)
+		http.Error(w, "Error storing upload", http.StatusInternalServerError)
+		return
+	}
+	if a.Size > model.MaxAttachmentSize {
+		storage.Store.Delete(context.Background(), a.StorageKey)
+		http.Error(w, "File too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	if media.CanThumbnail(contentType) {
+		createThumbnail(r.Context(), &a)
+	}
+
+	if err := model.CreateAttachment(&a); err != nil {
+		log.Println("error saving attachment:", err)
+		storage.Store.Delete(context.Background(), a.StorageKey)
+		if a.ThumbnailKey != "" {
+			storage.Store.Delete(context.Background(), a.ThumbnailKey)
+		}
+		http.Error(w, "Error saving attachment", http.StatusInternalServerError)
+		return
+	}
+
+	a.Sign()
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(a)
+}
+
+// uploadFilename returns the client's file name without any directories
+func uploadFilename(file io.Reader) string {
+	type named interface{ FileName() string }
+	if p, ok := file.(named); ok && p.FileName() != "" {
+		return filepath.Base(filepath.Clean("/" + p.FileName()))
+	}
+	return "file"
+}
+
+// createThumbnail stores a thumbnail of an image attachment and records its
+// dimensions. Images that fail to decode are kept without a thumbnail.
+func createThumbnail(ctx context.Context, a *model.Attachment) {
+	src, err := storage.Store.Get(ctx, a.StorageKey)
+	if err != nil {
+		log.Println("error opening image:", err)
+		return
+	}
+	a.Width, a.Height, err = media.DecodeConfig(src)
+	src.Close()
+	if err != nil {
+		log.Println("skipping thumbnail:", err)
+		return
+	}
+
+	src, err = storage.Store.Get(ctx, a.StorageKey)
+	if err != nil {
+		log.Println("error opening image:", err)
+		return
+	}
+	thumb, err := media.Thumbnail(src, media.ThumbnailSize)
+	src.Close()
+	if err != nil {
+		log.Println("skipping thumbnail:", err)
+		return
+	}
+
+	key := storage.NewKey()
+	if _, err := storage.Store.Put(ctx, key, bytes.NewReader(thumb), "image/jpeg"); err != nil {
+		log.Println("error storing thumbnail:", err)
+		return
+	}
+	a.ThumbnailKey = key
+}
+
+// getAttachmentHandler returns an attachment's metadata with fresh signed URLs
+// to members of its conversation
+func getAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := auth.GetUserIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Authentication error", http.StatusUnauthorized)
+		return
+	}
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "invalid attachment id", http.StatusBadRequest)
+		return
+	}
+	a, err := model.GetAttachmentByID(id)
+	if err != nil {
+		http.Error(w, "Attachment not found", http.StatusNotFound)
+		return
+	}
+
+	isMember, err := model.IsConversationMember(a.ConversationID, userID)
+	if err != nil {
+		http.Error(w, "Error checking membership", http.StatusInternalServerError)
+		return
+	}
+	if !isMember {
+		http.Error(w, "Forbidden: not a member of this conversation", http.StatusForbidden)
+		return
+	}
+
+	a.Sign()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(a)
+}
+
+// downloadAttachmentHandler serves an attachment or its thumbnail. It needs no
+// JWT so URLs work in <img> tags; the signature, handed out to members only,
+// is the authorization.
+func downloadAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !storage.VerifySignedURL(r.URL.Path, r.URL.Query()) {
+		http.Error(w, "Invalid or expired link", http.StatusForbidden)
+		return
+	}
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "invalid attachment id", http.StatusBadRequest)
+		return
+	}
+	a, err := model.GetAttachmentByID(id)
+	if err != nil {
+		http.Error(w, "Attachment not found", http.StatusNotFound)
+		return
+	}
+
+	thumbnail := strings.HasSuffix(r.URL.Path, "/thumbnail")
+	key, contentType := a.StorageKey, a.ContentType
+	if thumbnail {
+		if a.ThumbnailKey == "" {
+			http.Error(w, "No thumbnail", http.StatusNotFound)
+			return
+		}
+		key, contentType = a.ThumbnailKey, "image/jpeg"
+	}
+
+	blob, err := storage.Store.Get(r.Context(), key)
+	if err == storage.ErrNotFound {
+		http.Error(w, "Attachment not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println("error opening attachment:", err)
+		http.Error(w, "Error reading attachment", http.StatusInternalServerError)
+		return
+	}
+	defer blob.Close()
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "private, max-age=300")
+	if !thumbnail {
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": a.Filename}))
+	}
+	if _, err := io.Copy(w, blob); err != nil {
+		log.Println("error sending attachment:", err)
+	}
+}
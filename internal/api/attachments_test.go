@@ -0,0 +1,150 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"messaging-service/internal/db/dbtest"
+	"messaging-service/internal/model"
+	"messaging-service/internal/storage"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// useTempStore points the blob store at a fresh directory for one test
+func useTempStore(t *testing.T) {
+	t.Helper()
+	s, err := storage.NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := storage.Store
+	storage.Store = s
+	t.Cleanup(func() { storage.Store = prev })
+}
+
+// upload posts data as the multipart "file" field, as userID
+func upload(t *testing.T, userID string, conversationID int, filename string, data []byte) *httptest.ResponseRecorder {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, err := mw.CreateFormFile("file", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write(data)
+	mw.Close()
+
+	r := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/api/attachments?conversation_id=%d", conversationID), &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	r = r.WithContext(context.WithValue(r.Context(), "user_id", userID))
+	w := httptest.NewRecorder()
+	uploadAttachmentHandler(w, r)
+	return w
+}
+
+// download fetches a signed URL through the routes' path patterns
+func download(url string) *httptest.ResponseRecorder {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/attachments/{id}/content", downloadAttachmentHandler)
+	mux.HandleFunc("/api/attachments/{id}/thumbnail", downloadAttachmentHandler)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url, nil))
+	return w
+}
+
+func TestUploadAttachmentChecks(t *testing.T) {
+	dbtest.Require(t)
+	useTempStore(t)
+	owner := dbtest.CreateUser(t, "owner")
+	outsider := dbtest.CreateUser(t, "outsider")
+	c, err := model.CreateConversation(model.ConversationGroup, "team", owner, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prevMax := model.MaxAttachmentSize
+	model.MaxAttachmentSize = 1024
+	t.Cleanup(func() { model.MaxAttachmentSize = prevMax })
+
+	tests := []struct {
+		name   string
+		userID string
+		data   []byte
+		want   int
+	}{
+		{"non-member", outsider, []byte("hello"), http.StatusForbidden},
+		{"html", owner, []byte("<html><body>hi</body></html>"), http.StatusUnsupportedMediaType},
+		{"over the limit", owner, bytes.Repeat([]byte("a"), 1025), http.StatusRequestEntityTooLarge},
+		{"at the limit", owner, bytes.Repeat([]byte("a"), 1024), http.StatusCreated},
+	}
+	for _, tt := range tests {
+		if w := upload(t, tt.userID, c.ID, "file.txt", tt.data); w.Code != tt.want {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, w.Code, tt.want, w.Body.String())
+		}
+	}
+}
+
+func TestUploadAndDownloadImage(t *testing.T) {
+	dbtest.Require(t)
+	useTempStore(t)
+	owner := dbtest.CreateUser(t, "owner")
+	outsider := dbtest.CreateUser(t, "outsider")
+	c, err := model.CreateConversation(model.ConversationGroup, "team", owner, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var img bytes.Buffer
+	png.Encode(&img, image.NewGray(image.Rect(0, 0, 640, 480)))
+	w := upload(t, owner, c.ID, "../photo.png", img.Bytes())
+	if w.Code != http.StatusCreated {
+		t.Fatalf("upload: status %d: %s", w.Code, w.Body.String())
+	}
+	var a model.Attachment
+	if err := json.NewDecoder(w.Body).Decode(&a); err != nil {
+		t.Fatal(err)
+	}
+	if a.ContentType != "image/png" || a.Filename != "photo.png" || a.Width != 640 || a.Height != 480 {
+		t.Fatalf("attachment %+v", a)
+	}
+	if a.URL == "" || a.ThumbnailURL == "" {
+		t.Fatalf("missing signed URLs in %+v", a)
+	}
+
+	w = download(a.URL)
+	if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), img.Bytes()) {
+		t.Fatalf("download: status %d, %d bytes", w.Code, w.Body.Len())
+	}
+	if w = download(a.ThumbnailURL); w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/jpeg" {
+		t.Fatalf("thumbnail: status %d, type %q", w.Code, w.Header().Get("Content-Type"))
+	}
+
+	// The signature is the only authorization of a download
+	unsigned := strings.SplitN(a.URL, "?", 2)[0]
+	if w = download(unsigned); w.Code != http.StatusForbidden {
+		t.Fatalf("unsigned download: status %d, want 403", w.Code)
+	}
+	other := strings.Replace(a.URL, fmt.Sprintf("/%d/", a.ID), fmt.Sprintf("/%d/", a.ID+1), 1)
+	if w = download(other); w.Code != http.StatusForbidden {
+		t.Fatalf("download of another attachment: status %d, want 403", w.Code)
+	}
+
+	// Metadata and fresh URLs are for members only
+	r := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/attachments/%d", a.ID), nil)
+	r.SetPathValue("id", fmt.Sprint(a.ID))
+	r = r.WithContext(context.WithValue(r.Context(), "user_id", outsider))
+	w = httptest.NewRecorder()
+	getAttachmentHandler(w, r)
+	if w.Code != http.StatusForbidden {
+		body, _ := io.ReadAll(w.Body)
+		t.Fatalf("non-member metadata: status %d, want 403: %s", w.Code, body)
+	}
+}
@@ -0,0 +1,153 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"messaging-service/internal/auth"
+	"messaging-service/internal/model"
+	"net/http"
+)
+
+// conversationsHandler lists the caller's conversations (GET) or creates a
+// group/channel, or returns the direct conversation with another user (POST)
+func conversationsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := auth.GetUserIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Authentication error", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		conversations, err := model.GetConversationsForUser(userID)
+		if err != nil {
+			log.Println("error fetching conversations:", err)
+			http.Error(w, "Error fetching conversations", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(conversations)
+
+	case http.MethodPost:
+		var req struct {
+			Type      string   `json:"type"`
+			Name      string   `json:"name"`
+			MemberIDs []string `json:"member_ids"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid payload", http.StatusBadRequest)
+			return
+		}
+		if !model.IsValidConversationType(req.Type) {
+			http.Error(w, "type must be direct, group or channel", http.StatusBadRequest)
+			return
+		}
+
+		var c *model.Conversation
+		if req.Type == model.ConversationDirect {
+			if len(req.MemberIDs) != 1 {
+				http.Error(w, "direct conversations need exactly one other member", http.StatusBadRequest)
+				return
+			}
+			c, err = model.GetOrCreateDirectConversation(userID, req.MemberIDs[0])
+		} else {
+			c, err = model.CreateConversation(req.Type, req.Name, userID, req.MemberIDs)
+		}
+		if err == model.ErrUnknownUser {
+			http.Error(w, "member_ids must be user IDs", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			log.Println("error creating conversation:", err)
+			http.Error(w, "Error creating conversation", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(c)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// conversationMembersHandler adds (POST) or removes (DELETE) a member of a group
+// or channel. Only owners and admins may change membership; any member may
+// remove themselves. The last owner cannot be removed.
+func conversationMembersHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := auth.GetUserIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Authentication error", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		ConversationID int    `json:"conversation_id"`
+		UserID         string `json:"user_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ConversationID == 0 || req.UserID == "" {
+		http.Error(w, "conversation_id and user_id required", http.StatusBadRequest)
+		return
+	}
+
+	c, err := model.GetConversationByID(req.ConversationID)
+	if err != nil {
+		http.Error(w, "Conversation not found", http.StatusNotFound)
+		return
+	}
+	if c.Type == model.ConversationDirect {
+		http.Error(w, "Cannot change members of a direct conversation", http.StatusBadRequest)
+		return
+	}
+
+	role, err := model.GetConversationMemberRole(c.ID, userID)
+	if err != nil {
+		http.Error(w, "Error checking membership", http.StatusInternalServerError)
+		return
+	}
+	if role == "" {
+		http.Error(w, "Forbidden: not a member of this conversation", http.StatusForbidden)
+		return
+	}
+	leaving := r.Method == http.MethodDelete && req.UserID == userID
+	if !leaving && !model.CanManageMembers(role) {
+		http.Error(w, "Forbidden: only owners and admins can change members", http.StatusForbidden)
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		err = model.AddConversationMember(c.ID, req.UserID)
+	} else {
+		err = model.RemoveConversationMember(c.ID, req.UserID)
+	}
+	if err == model.ErrLastOwner {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err == model.ErrUnknownUser {
+		http.Error(w, "user_id must be a user ID", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Println("error updating conversation members:", err)
+		http.Error(w, "Error updating members", http.StatusInternalServerError)
+		return
+	}
+
+	memberIDs, err := model.GetConversationMemberIDs(c.ID)
+	if err != nil {
+		http.Error(w, "Error fetching members", http.StatusInternalServerError)
+		return
+	}
+	c.MemberIDs = memberIDs
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c)
+}
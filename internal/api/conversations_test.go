@@ -0,0 +1,76 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"messaging-service/internal/db/dbtest"
+	"messaging-service/internal/model"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// changeMember calls the members endpoint as userID, like JWTMiddleware would
+func changeMember(method, userID string, conversationID int, targetID string) *httptest.ResponseRecorder {
+	body := fmt.Sprintf(`{"conversation_id": %d, "user_id": %q}`, conversationID, targetID)
+	r := httptest.NewRequest(method, "/api/conversations/members", strings.NewReader(body))
+	r = r.WithContext(context.WithValue(r.Context(), "user_id", userID))
+	w := httptest.NewRecorder()
+	conversationMembersHandler(w, r)
+	return w
+}
+
+func TestConversationMembersRequiresOwnerOrAdmin(t *testing.T) {
+	dbtest.Require(t)
+	owner := dbtest.CreateUser(t, "owner")
+	member := dbtest.CreateUser(t, "member")
+	outsider := dbtest.CreateUser(t, "outsider")
+
+	c, err := model.CreateConversation(model.ConversationGroup, "team", owner, []string{member})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if w := changeMember(http.MethodPost, member, c.ID, outsider); w.Code != http.StatusForbidden {
+		t.Fatalf("member adding a user: status %d, want 403", w.Code)
+	}
+	if w := changeMember(http.MethodDelete, member, c.ID, owner); w.Code != http.StatusForbidden {
+		t.Fatalf("member removing the owner: status %d, want 403", w.Code)
+	}
+	if w := changeMember(http.MethodPost, owner, c.ID, outsider); w.Code != http.StatusOK {
+		t.Fatalf("owner adding a user: status %d, want 200", w.Code)
+	}
+	if w := changeMember(http.MethodDelete, owner, c.ID, owner); w.Code != http.StatusConflict {
+		t.Fatalf("last owner leaving: status %d, want 409", w.Code)
+	}
+	if w := changeMember(http.MethodDelete, member, c.ID, member); w.Code != http.StatusOK {
+		t.Fatalf("member leaving: status %d, want 200", w.Code)
+	}
+}
+
+func TestConversationsRejectUnknownMembers(t *testing.T) {
+	dbtest.Require(t)
+	owner := dbtest.CreateUser(t, "owner")
+
+	for _, body := range []string{
+		`{"type": "group", "name": "team", "member_ids": ["bot-x"]}`,
+		`{"type": "direct", "member_ids": ["999999"]}`,
+	} {
+		r := httptest.NewRequest(http.MethodPost, "/api/conversations", strings.NewReader(body))
+		r = r.WithContext(context.WithValue(r.Context(), "user_id", owner))
+		w := httptest.NewRecorder()
+		conversationsHandler(w, r)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", body, w.Code)
+		}
+	}
+
+	c, err := model.CreateConversation(model.ConversationGroup, "team", owner, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := changeMember(http.MethodPost, owner, c.ID, "bot-x"); w.Code != http.StatusBadRequest {
+		t.Fatalf("adding a non-user: status %d, want 400", w.Code)
+	}
+}
@@ -3,11 +3,13 @@ package api
 import (
 	"encoding/json"
 	"fmt"
+	"log"
 	"messaging-service/internal/auth"
 	"messaging-service/internal/db"
 	"messaging-service/internal/model"
 	"messaging-service/internal/websocket"
 	"net/http"
+	"strconv"
 )
 
 func getMessagesHandler(w http.ResponseWriter, r *http.Request) {
@@ -18,22 +20,95 @@ func getMessagesHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Get the other user from query parameter
-	userB := r.URL.Query().Get("with")
-	if userB == "" {
-		http.Error(w, "with query param required", http.StatusBadRequest)
-		return
+	query := r.URL.Query()
+
+	var conversationID int
+	if convParam := query.Get("conversation_id"); convParam != "" {
+		// Conversation history (direct, group or channel)
+		conversationID, err = strconv.Atoi(convParam)
+		if err != nil {
+			http.Error(w, "invalid conversation_id", http.StatusBadRequest)
+			return
+		}
+
+		isMember, err := model.IsConversationMember(conversationID, userA)
+		if err != nil {
+			http.Error(w, "Error fetching messages", http.StatusInternalServerError)
+			return
+		}
+		if !isMember {
+			http.Error(w, "Forbidden: not a member of this conversation", http.StatusForbidden)
+			return
+		}
+	} else {
+		// Get the other user from query parameter
+		userB := query.Get("with")
+		if userB == "" {
+			http.Error(w, "conversation_id or with query param required", http.StatusBadRequest)
+			return
+		}
+
+		conversationID, err = model.FindDirectConversationID(userA, userB)
+		if err != nil {
+			log.Println("error fetching direct conversation:", err)
+			http.Error(w, "Error fetching messages", http.StatusInternalServerError)
+			return
+		}
 	}
 
-	messages, err := model.GetMessagesBetweenWithUsers(userA, userB)
-	fmt.Println("error", err)
+	pageQuery, err := parsePageQuery(r)
 	if err != nil {
-		http.Error(w, "Error fetching messages", http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	pageQuery.ViewerID = userA
+
+	// Users who never talked have no direct conversation yet
+	page := &model.MessagePage{Messages: []model.MessageWithUser{}}
+	if conversationID != 0 {
+		page, err = model.GetConversationPageWithUsers(conversationID, pageQuery)
+		if err != nil {
+			log.Println("error fetching messages:", err)
+			http.Error(w, "Error fetching messages", http.StatusInternalServerError)
+			return
+		}
+	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(messages)
+	json.NewEncoder(w).Encode(page)
+}
+
+// parsePageQuery reads the before/after cursors and limit of a history request
+func parsePageQuery(r *http.Request) (model.PageQuery, error) {
+	var q model.PageQuery
+	query := r.URL.Query()
+
+	if before := query.Get("before"); before != "" {
+		c, err := model.DecodeCursor(before)
+		if err != nil {
+			return q, fmt.Errorf("invalid before cursor")
+		}
+		q.Before = c
+	}
+	if after := query.Get("after"); after != "" {
+		c, err := model.DecodeCursor(after)
+		if err != nil {
+			return q, fmt.Errorf("invalid after cursor")
+		}
+		q.After = c
+	}
+	if q.Before != nil && q.After != nil {
+		return q, fmt.Errorf("before and after cannot be combined")
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 {
+			return q, fmt.Errorf("limit must be a positive integer")
+		}
+		q.Limit = n
+	}
+	return q, nil
 }
 
 func getOnlineUsersHandler(w http.ResponseWriter, r *http.Request) {
@@ -59,10 +134,17 @@ func getOnlineUsersHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Convert map to slice for JSON response with full user details
-	var usersWithDetails []model.User
-	for _, user := range userMap {
-		usersWithDetails = append(usersWithDetails, user)
+	// Convert to a slice for JSON response with full user details and presence
+	type userWithPresence struct {
+		model.User
+		State      string `json:"state"`
+		StatusText string `json:"status_text,omitempty"`
+	}
+	var usersWithDetails []userWithPresence
+	for _, online := range onlineUsers {
+		if user, ok := userMap[online.ID]; ok {
+			usersWithDetails = append(usersWithDetails, userWithPresence{user, online.State, online.StatusText})
+		}
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -82,34 +164,291 @@ func sendMessageHandler(w http.ResponseWriter, r *http.Request, hub *websocket.H
 		return
 	}
 
-	var m model.Message
-	if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+	var req model.SendRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid payload", http.StatusBadRequest)
 		return
 	}
 
-	// Enforce sender ID from JWT (prevent spoofing)
-	m.SenderID = senderID
-	m.Status = "sent"
+	// The Idempotency-Key header is an alternative to client_message_id in the body
+	if req.ClientMessageID == "" {
+		req.ClientMessageID = r.Header.Get("Idempotency-Key")
+	}
+
+	// Sender ID comes from the JWT and server-owned fields are never decoded
+	// (prevent spoofing)
+	m := req.NewMessage(senderID)
 
-	// Save to DB
-	if err := m.Save(); err != nil {
+	// Resolve the target conversation (conversation_id, or receiver_id for 1:1)
+	if err := m.ResolveConversation(); err != nil {
+		switch err {
+		case model.ErrNoConversationTarget, model.ErrInvalidParent, model.ErrMessageDeleted, model.ErrUnknownUser, model.ErrInvalidReceiver:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case model.ErrNotConversationMember:
+			http.Error(w, "Forbidden: "+err.Error(), http.StatusForbidden)
+		case model.ErrMessageNotFound:
+			http.Error(w, "Parent message not found", http.StatusNotFound)
+		default:
+			http.Error(w, "Error resolving conversation", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// Save to DB; a retried idempotency key returns the originally stored message
+	created, err := m.Save()
+	if err == model.ErrClientMessageIDLength || err == model.ErrInvalidAttachment {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
 		http.Error(w, "Error saving message", http.StatusInternalServerError)
 		return
 	}
 
-	// Send via WebSocket if recipient online
-	data, _ := json.Marshal(m)
-	hub.SendMessage(m.ReceiverID, data)
+	// Deliver via WebSocket to every online member; offline members get it on reconnect.
+	// Retries were already delivered when first stored.
+	if created {
+		if err := hub.DeliverMessage(&m, nil); err != nil {
+			log.Println("error delivering message:", err)
+		}
+	}
 
-	// Update status to delivered
-	m.Status = "delivered"
-	m.UpdateStatus()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(m)
+}
+
+// getMessageReceiptsHandler returns the per-recipient delivered/read timestamps
+// of a message to members of its conversation
+func getMessageReceiptsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := auth.GetUserIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Authentication error", http.StatusUnauthorized)
+		return
+	}
+
+	m, ok := loadMemberMessage(w, r, userID)
+	if !ok {
+		return
+	}
+
+	receipts, err := model.GetMessageReceipts(m.ID)
+	if err != nil {
+		log.Println("error fetching receipts:", err)
+		http.Error(w, "Error fetching receipts", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message_id": m.ID,
+		"status":     m.Status,
+		"receipts":   receipts,
+	})
+}
+
+// loadMemberMessage loads the message named by the message_id query param and
+// checks that the user belongs to its conversation. On failure it writes the
+// error response and returns false.
+func loadMemberMessage(w http.ResponseWriter, r *http.Request, userID string) (*model.Message, bool) {
+	messageID, err := strconv.Atoi(r.URL.Query().Get("message_id"))
+	if err != nil {
+		http.Error(w, "message_id query param required", http.StatusBadRequest)
+		return nil, false
+	}
+
+	m, err := model.GetMessageByID(messageID)
+	if err != nil {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return nil, false
+	}
+
+	isMember, err := model.IsConversationMember(m.ConversationID, userID)
+	if err != nil {
+		http.Error(w, "Error checking membership", http.StatusInternalServerError)
+		return nil, false
+	}
+	if !isMember {
+		http.Error(w, "Forbidden: not a member of this conversation", http.StatusForbidden)
+		return nil, false
+	}
+	return m, true
+}
+
+// editMessageHandler changes the content of one of the caller's messages and
+// broadcasts the edit to the conversation
+func editMessageHandler(w http.ResponseWriter, r *http.Request, hub *websocket.Hub) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := auth.GetUserIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Authentication error", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		MessageID int    `json:"message_id"`
+		Content   string `json:"content"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.MessageID == 0 {
+		http.Error(w, "message_id and content required", http.StatusBadRequest)
+		return
+	}
+
+	m, err := model.EditMessage(req.MessageID, userID, req.Content)
+	switch err {
+	case nil:
+	case model.ErrEmptyContent, model.ErrMessageDeleted:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case model.ErrMessageNotFound:
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	case model.ErrNotMessageSender:
+		http.Error(w, "Forbidden: "+err.Error(), http.StatusForbidden)
+		return
+	default:
+		log.Println("error editing message:", err)
+		http.Error(w, "Error editing message", http.StatusInternalServerError)
+		return
+	}
+
+	if err := hub.BroadcastMessageEdited(m, nil); err != nil {
+		log.Println("error broadcasting edit:", err)
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(m)
 }
 
+// deleteMessageHandler deletes a message for the caller ("me") or, for its
+// sender, for everyone
+func deleteMessageHandler(w http.ResponseWriter, r *http.Request, hub *websocket.Hub) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := auth.GetUserIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Authentication error", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		MessageID int    `json:"message_id"`
+		Scope     string `json:"scope"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.MessageID == 0 {
+		http.Error(w, "message_id and scope required", http.StatusBadRequest)
+		return
+	}
+
+	m, err := hub.DeleteMessage(req.MessageID, userID, req.Scope, nil)
+	switch err {
+	case nil:
+	case model.ErrInvalidDeleteScope, model.ErrDeleteWindowPassed:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case model.ErrMessageNotFound:
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	case model.ErrNotMessageSender, model.ErrNotConversationMember:
+		http.Error(w, "Forbidden: "+err.Error(), http.StatusForbidden)
+		return
+	default:
+		log.Println("error deleting message:", err)
+		http.Error(w, "Error deleting message", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(websocket.MessageDeletedPayload{
+		MessageID:      m.ID,
+		ConversationID: m.ConversationID,
+		Scope:          req.Scope,
+		DeletedAt:      m.DeletedAt,
+	})
+}
+
+// reactionsHandler adds (POST) or removes (DELETE) the caller's emoji reaction
+// on a message
+func reactionsHandler(w http.ResponseWriter, r *http.Request, hub *websocket.Hub) {
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := auth.GetUserIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Authentication error", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		MessageID int    `json:"message_id"`
+		Emoji     string `json:"emoji"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.MessageID == 0 {
+		http.Error(w, "message_id and emoji required", http.StatusBadRequest)
+		return
+	}
+
+	var reaction *model.Reaction
+	if r.Method == http.MethodPost {
+		reaction, err = hub.AddReaction(req.MessageID, userID, req.Emoji, nil)
+	} else {
+		reaction, err = hub.RemoveReaction(req.MessageID, userID, req.Emoji, nil)
+	}
+
+	switch err {
+	case nil:
+	case model.ErrInvalidEmoji, model.ErrMessageDeleted:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case model.ErrMessageNotFound:
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	case model.ErrNotConversationMember:
+		http.Error(w, "Forbidden: "+err.Error(), http.StatusForbidden)
+		return
+	default:
+		log.Println("error updating reaction:", err)
+		http.Error(w, "Error updating reaction", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reaction)
+}
+
+// getMessageEditsHandler returns the previous versions of a message to members
+// of its conversation
+func getMessageEditsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := auth.GetUserIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Authentication error", http.StatusUnauthorized)
+		return
+	}
+
+	m, ok := loadMemberMessage(w, r, userID)
+	if !ok {
+		return
+	}
+
+	edits, err := model.GetMessageEdits(m.ID)
+	if err != nil {
+		log.Println("error fetching edits:", err)
+		http.Error(w, "Error fetching edits", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(edits)
+}
+
 // DatabaseInfoHandler returns information about the connected database
 func DatabaseInfoHandler(w http.ResponseWriter, r *http.Request) {
 	type DatabaseInfo struct {
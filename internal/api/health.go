@@ -0,0 +1,51 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"messaging-service/internal/health"
+	"net/http"
+)
+
+// healthzHandler is the liveness probe: the process is up and serving HTTP
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte("ok\n"))
+}
+
+// readyzHandler is the readiness probe: it pings Postgres and Redis and
+// answers 503 unless both are reachable. Errors are logged, not returned.
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	results := health.Check(r.Context())
+	checks := make(map[string]string, len(results))
+	for name, err := range results {
+		if err != nil {
+			log.Printf("readiness check %s failed: %v", name, err)
+			checks[name] = "unavailable"
+			continue
+		}
+		checks[name] = "ok"
+	}
+
+	resp := struct {
+		Status string            `json:"status"`
+		Checks map[string]string `json:"checks"`
+	}{Status: "ready", Checks: checks}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if !health.Ready(results) {
+		resp.Status = "unavailable"
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,85 @@
+package api
+
+import (
+	"encoding/json"
+	"messaging-service/internal/db/dbtest"
+	"messaging-service/internal/redis"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	goredis "github.com/go-redis/redis/v8"
+)
+
+// startRedis points the redis package at an in-process Redis for the test
+func startRedis(t *testing.T) *miniredis.Miniredis {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	prev := redis.Client
+	redis.Client = goredis.NewClient(&goredis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() {
+		redis.Client.Close()
+		redis.Client = prev
+	})
+	return mr
+}
+
+// readyz calls the readiness probe and returns its status and checks
+func readyz(t *testing.T) (int, string, map[string]string) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	readyzHandler(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+	var resp struct {
+		Status string            `json:"status"`
+		Checks map[string]string `json:"checks"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	return w.Code, resp.Status, resp.Checks
+}
+
+func TestReadyzWhenPostgresIsDown(t *testing.T) {
+	mr := startRedis(t)
+	dbtest.Unreachable(t)
+
+	code, status, checks := readyz(t)
+	if code != http.StatusServiceUnavailable || status != "unavailable" ||
+		checks["postgres"] != "unavailable" || checks["redis"] != "ok" {
+		t.Fatalf("postgres down: %d %s %v", code, status, checks)
+	}
+
+	mr.Close()
+	code, _, checks = readyz(t)
+	if code != http.StatusServiceUnavailable || checks["postgres"] != "unavailable" || checks["redis"] != "unavailable" {
+		t.Fatalf("both down: %d %v", code, checks)
+	}
+}
+
+func TestReadyzWhenRedisIsDown(t *testing.T) {
+	dbtest.Require(t)
+	mr := startRedis(t)
+
+	code, status, checks := readyz(t)
+	if code != http.StatusOK || status != "ready" || checks["postgres"] != "ok" || checks["redis"] != "ok" {
+		t.Fatalf("all up: %d %s %v", code, status, checks)
+	}
+
+	mr.Close()
+	code, status, checks = readyz(t)
+	if code != http.StatusServiceUnavailable || status != "unavailable" ||
+		checks["postgres"] != "ok" || checks["redis"] != "unavailable" {
+		t.Fatalf("redis down: %d %s %v", code, status, checks)
+	}
+}
+
+func TestHealthzIgnoresDependencies(t *testing.T) {
+	dbtest.Unreachable(t)
+	w := httptest.NewRecorder()
+	healthzHandler(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("liveness with postgres down: %d", w.Code)
+	}
+}
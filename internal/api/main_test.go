@@ -0,0 +1,11 @@
+package api
+
+import (
+	"messaging-service/internal/db/dbtest"
+	"os"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	os.Exit(dbtest.Main(m))
+}
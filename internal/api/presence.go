@@ -0,0 +1,70 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"messaging-service/internal/auth"
+	"messaging-service/internal/redis"
+	"messaging-service/internal/websocket"
+	"net/http"
+)
+
+// presenceHandler returns (GET) or sets (POST) the caller's chosen presence
+// state and custom status
+func presenceHandler(w http.ResponseWriter, r *http.Request, hub *websocket.Hub) {
+	userID, err := auth.GetUserIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Authentication error", http.StatusUnauthorized)
+		return
+	}
+
+	var s redis.Status
+	switch r.Method {
+	case http.MethodGet:
+		s, err = redis.GetStatus(userID)
+		if err != nil {
+			http.Error(w, "Error fetching status", http.StatusInternalServerError)
+			return
+		}
+	case http.MethodPost:
+		if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+			http.Error(w, "Invalid payload", http.StatusBadRequest)
+			return
+		}
+		if err := hub.SetStatus(userID, &s); err != nil {
+			switch err {
+			case redis.ErrInvalidPresenceState, redis.ErrStatusTextLength:
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			default:
+				log.Println("error setting status:", err)
+				http.Error(w, "Error setting status", http.StatusInternalServerError)
+			}
+			return
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s)
+}
+
+// getUserPresenceHandler returns another user's presence, with last_seen_at
+// when they are offline
+func getUserPresenceHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	p, err := websocket.GetPresence(r.PathValue("id"))
+	if err != nil {
+		log.Println("error fetching presence:", err)
+		http.Error(w, "Error fetching presence", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(p)
+}
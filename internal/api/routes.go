@@ -8,17 +8,68 @@ import (
 
 func RegisterRoutes(hub *ws.Hub) {
 	// Protected endpoints with JWT authentication and CORS
-	// Messages endpoint - get message history between users
+	// Messages endpoint - get message history of a conversation or between users
 	http.Handle("/api/messages", enableCORS(auth.JWTMiddleware(getMessagesHandler)))
 
+	// Receipts endpoint - per-recipient delivered/read timestamps of a message
+	http.Handle("/api/messages/receipts", enableCORS(auth.JWTMiddleware(getMessageReceiptsHandler)))
+
+	// Edit endpoints - change a sent message and read its edit history
+	http.Handle("/api/messages/edit", enableCORS(auth.JWTMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		editMessageHandler(w, r, hub)
+	})))
+	http.Handle("/api/messages/edits", enableCORS(auth.JWTMiddleware(getMessageEditsHandler)))
+
+	// Delete endpoint - delete a message for yourself or for everyone
+	http.Handle("/api/messages/delete", enableCORS(auth.JWTMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		deleteMessageHandler(w, r, hub)
+	})))
+
+	// Reactions endpoint - add (POST) or remove (DELETE) an emoji reaction
+	http.Handle("/api/messages/reactions", enableCORS(auth.JWTMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		reactionsHandler(w, r, hub)
+	})))
+
+	// Attachment endpoints - upload a file, fetch its metadata and signed URLs, download
+	// it (signed URL, no JWT) and its image thumbnail
+	http.Handle("/api/attachments", enableCORS(auth.JWTMiddleware(uploadAttachmentHandler)))
+	http.Handle("/api/attachments/{id}", enableCORS(auth.JWTMiddleware(getAttachmentHandler)))
+	http.Handle("/api/attachments/{id}/content", enableCORS(http.HandlerFunc(downloadAttachmentHandler)))
+	http.Handle("/api/attachments/{id}/thumbnail", enableCORS(http.HandlerFunc(downloadAttachmentHandler)))
+
+	// Threads endpoint - a thread root and a page of its replies
+	http.Handle("/api/threads/{id}", enableCORS(auth.JWTMiddleware(getThreadHandler)))
+
+	// Search endpoint - full-text search over the caller's conversations
+	http.Handle("/api/search", enableCORS(auth.JWTMiddleware(searchHandler)))
+
+	// Conversations endpoint - list or create direct, group and channel conversations
+	http.Handle("/api/conversations", enableCORS(auth.JWTMiddleware(conversationsHandler)))
+
+	// Conversation members endpoint - add or remove group/channel members
+	http.Handle("/api/conversations/members", enableCORS(auth.JWTMiddleware(conversationMembersHandler)))
+
 	// Online users endpoint - get list of currently online users
 	http.Handle("/api/online_users", enableCORS(auth.JWTMiddleware(getOnlineUsersHandler)))
 
+	// Presence endpoints - get or set your own status, get another user's presence
+	http.Handle("/api/presence", enableCORS(auth.JWTMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		presenceHandler(w, r, hub)
+	})))
+	http.Handle("/api/users/{id}/presence", enableCORS(auth.JWTMiddleware(getUserPresenceHandler)))
+
 	// Send message endpoint - send a message via REST API
 	http.Handle("/api/send_message", enableCORS(auth.JWTMiddleware(func(w http.ResponseWriter, r *http.Request) {
 		sendMessageHandler(w, r, hub)
 	})))
 
+	// WebSocket stats endpoint - frames dropped and clients disconnected for full buffers (admins only)
+	http.Handle("/api/admin/websocket_stats", enableCORS(auth.JWTMiddleware(websocketStatsHandler)))
+
+	// Health probes (no auth) - liveness, and readiness checking Postgres and Redis
+	http.HandleFunc("/healthz", healthzHandler)
+	http.HandleFunc("/readyz", readyzHandler)
+
 	// Database info endpoint - shows which database is connected (no auth required for debugging)
 	http.Handle("/api/db-info", enableCORS(http.HandlerFunc(DatabaseInfoHandler)))
 }
@@ -0,0 +1,90 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"messaging-service/internal/auth"
+	"messaging-service/internal/model"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// searchHandler runs a full-text search over the caller's conversations.
+// Query params: q (required), sender_id, conversation_id, from, to (RFC 3339
+// or YYYY-MM-DD), limit and offset.
+func searchHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := auth.GetUserIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Authentication error", http.StatusUnauthorized)
+		return
+	}
+
+	query := r.URL.Query()
+	q := model.SearchQuery{
+		UserID:   userID,
+		Text:     query.Get("q"),
+		SenderID: query.Get("sender_id"),
+	}
+	if q.Text == "" {
+		http.Error(w, "q query param required", http.StatusBadRequest)
+		return
+	}
+
+	if v := query.Get("conversation_id"); v != "" {
+		if q.ConversationID, err = strconv.Atoi(v); err != nil {
+			http.Error(w, "invalid conversation_id", http.StatusBadRequest)
+			return
+		}
+	}
+	if v := query.Get("from"); v != "" {
+		t, err := parseSearchDate(v)
+		if err != nil {
+			http.Error(w, "invalid from date", http.StatusBadRequest)
+			return
+		}
+		q.From = &t
+	}
+	if v := query.Get("to"); v != "" {
+		t, err := parseSearchDate(v)
+		if err != nil {
+			http.Error(w, "invalid to date", http.StatusBadRequest)
+			return
+		}
+		// A bare date includes the whole day
+		if len(v) == len("2006-01-02") {
+			t = t.AddDate(0, 0, 1)
+		}
+		q.To = &t
+	}
+	if v := query.Get("limit"); v != "" {
+		if q.Limit, err = strconv.Atoi(v); err != nil || q.Limit <= 0 {
+			http.Error(w, "limit must be a positive integer", http.StatusBadRequest)
+			return
+		}
+	}
+	if v := query.Get("offset"); v != "" {
+		if q.Offset, err = strconv.Atoi(v); err != nil || q.Offset < 0 {
+			http.Error(w, "offset must be a non-negative integer", http.StatusBadRequest)
+			return
+		}
+	}
+
+	results, err := model.SearchMessages(q)
+	if err != nil {
+		log.Println("error searching messages:", err)
+		http.Error(w, "Error searching messages", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
+
+// parseSearchDate accepts an RFC 3339 timestamp or a YYYY-MM-DD date (UTC)
+func parseSearchDate(v string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", v)
+}
@@ -0,0 +1,34 @@
+package api
+
+import (
+	"messaging-service/internal/auth"
+	"messaging-service/internal/model"
+	ws "messaging-service/internal/websocket"
+	"net/http"
+)
+
+// websocketStatsHandler reports the WebSocket overflow counters to admins
+func websocketStatsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := auth.GetUserIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	role, err := model.GetUserRole(userID)
+	if err == model.ErrUnknownUser || (err == nil && role != model.UserRoleAdmin) {
+		http.Error(w, "Forbidden: admin only", http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to check role", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(ws.SendStats()))
+}
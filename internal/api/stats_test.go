@@ -0,0 +1,47 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"messaging-service/internal/db"
+	"messaging-service/internal/db/dbtest"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWebsocketStatsNotPublished(t *testing.T) {
+	if expvar.Get("websocket_overflow") != nil {
+		t.Fatal("websocket_overflow is published at /debug/vars")
+	}
+}
+
+func TestWebsocketStatsAdminOnly(t *testing.T) {
+	dbtest.Require(t)
+	member := dbtest.CreateUser(t, "member")
+	admin := dbtest.CreateUser(t, "admin")
+	if _, err := db.DB.Exec(`UPDATE users SET role = 1 WHERE id = $1`, admin); err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(userID string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/api/admin/websocket_stats", nil)
+		r = r.WithContext(context.WithValue(r.Context(), "user_id", userID))
+		w := httptest.NewRecorder()
+		websocketStatsHandler(w, r)
+		return w
+	}
+
+	if w := get(member); w.Code != http.StatusForbidden {
+		t.Fatalf("member: status %d, want 403", w.Code)
+	}
+	w := get(admin)
+	if w.Code != http.StatusOK {
+		t.Fatalf("admin: status %d, want 200", w.Code)
+	}
+	var stats map[string]int64
+	if err := json.Unmarshal(w.Body.Bytes(), &stats); err != nil {
+		t.Fatalf("stats %q: %v", w.Body.String(), err)
+	}
+}
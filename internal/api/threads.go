@@ -0,0 +1,68 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"messaging-service/internal/auth"
+	"messaging-service/internal/model"
+	"net/http"
+	"strconv"
+)
+
+// getThreadHandler returns a thread root and one page of its replies. It
+// takes the same before/after/limit params as the history endpoint.
+func getThreadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := auth.GetUserIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Authentication error", http.StatusUnauthorized)
+		return
+	}
+
+	rootID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "invalid thread id", http.StatusBadRequest)
+		return
+	}
+
+	root, err := model.GetMessageByID(rootID)
+	if err != nil {
+		http.Error(w, "Thread not found", http.StatusNotFound)
+		return
+	}
+	if root.ParentID != 0 {
+		http.Error(w, "Message is a reply, not a thread root", http.StatusBadRequest)
+		return
+	}
+
+	isMember, err := model.IsConversationMember(root.ConversationID, userID)
+	if err != nil {
+		http.Error(w, "Error checking membership", http.StatusInternalServerError)
+		return
+	}
+	if !isMember {
+		http.Error(w, "Forbidden: not a member of this conversation", http.StatusForbidden)
+		return
+	}
+
+	pageQuery, err := parsePageQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	pageQuery.ViewerID = userID
+
+	page, err := model.GetThreadPageWithUsers(rootID, pageQuery)
+	if err != nil {
+		log.Println("error fetching thread:", err)
+		http.Error(w, "Error fetching thread", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(model.ThreadPage{Root: root, MessagePage: *page})
+}
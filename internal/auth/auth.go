@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"slices"
 	"strings"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -43,6 +44,23 @@ func VerifyToken(tokenStr string, pubKey *rsa.PublicKey) (*CustomClaims, error)
 	return nil, fmt.Errorf("invalid token")
 }
 
+// ServiceAudience is the JWT audience that marks service tokens
+// (GRPC_SERVICE_AUDIENCE). Only the gRPC API accepts those; REST and
+// WebSocket clients act as users.
+var ServiceAudience string
+
+// VerifyUserToken is VerifyToken for end-user endpoints: it refuses service tokens
+func VerifyUserToken(tokenStr string, pubKey *rsa.PublicKey) (*CustomClaims, error) {
+	claims, err := VerifyToken(tokenStr, pubKey)
+	if err != nil {
+		return nil, err
+	}
+	if ServiceAudience != "" && slices.Contains(claims.Audience, ServiceAudience) {
+		return nil, fmt.Errorf("service tokens are only accepted by the gRPC API")
+	}
+	return claims, nil
+}
+
 // Extract Bearer token from Authorization header
 func ExtractBearerToken(r *http.Request) (string, error) {
 	authHeader := r.Header.Get("Authorization")
@@ -85,7 +103,7 @@ func JWTMiddleware(next http.HandlerFunc) http.HandlerFunc {
 		}
 
 		// Verify token
-		claims, err := VerifyToken(tokenStr, pubKey)
+		claims, err := VerifyUserToken(tokenStr, pubKey)
 		if err != nil {
 			http.Error(w, "Unauthorized: "+err.Error(), http.StatusUnauthorized)
 			return
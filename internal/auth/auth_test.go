@@ -0,0 +1,83 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// useTestKey sets JWT_PUBLIC_KEY for the test and returns the signing key
+func useTestKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("JWT_PUBLIC_KEY", string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})))
+	return key
+}
+
+func signToken(t *testing.T, key *rsa.PrivateKey, subject string, audience ...string) string {
+	t.Helper()
+	claims := CustomClaims{RegisteredClaims: jwt.RegisteredClaims{
+		Subject:   subject,
+		Audience:  audience,
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodRS256, claims).SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestJWTMiddlewareRefusesServiceTokens(t *testing.T) {
+	key := useTestKey(t)
+	ServiceAudience = "messaging-services"
+	t.Cleanup(func() { ServiceAudience = "" })
+
+	handler := JWTMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		userID, _ := GetUserIDFromContext(r.Context())
+		w.Write([]byte(userID))
+	})
+	call := func(token string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/api/conversations", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		want  int
+	}{
+		{"user token", signToken(t, key, "1"), http.StatusOK},
+		{"user token for another audience", signToken(t, key, "1", "web"), http.StatusOK},
+		{"service token", signToken(t, key, "billing", "messaging-services"), http.StatusUnauthorized},
+		{"service token naming a user", signToken(t, key, "1", "web", "messaging-services"), http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		if w := call(tt.token); w.Code != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.want)
+		}
+	}
+
+	// Without a configured service audience no token is a service token
+	ServiceAudience = ""
+	if w := call(signToken(t, key, "1", "messaging-services")); w.Code != http.StatusOK {
+		t.Errorf("no service audience configured: status %d", w.Code)
+	}
+}
@@ -1,8 +1,11 @@
 package config
 
 import (
+	"fmt"
 	"log"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -11,6 +14,51 @@ type Config struct {
 	PostgresDSN string
 	RedisAddr   string
 	AppEnv      string
+	NodeID      string
+
+	// How long a connection stays online in Redis without a heartbeat, and
+	// without client activity before it counts as idle
+	PresenceTTL       time.Duration
+	PresenceIdleAfter time.Duration
+
+	// WebSocket keepalive and frame limits
+	WSPingInterval   time.Duration
+	WSPongWait       time.Duration
+	WSWriteWait      time.Duration
+	WSMaxMessageSize int64
+
+	// What to do when a client's send buffer is full: "disconnect" or "spill"
+	WSOverflowPolicy string
+
+	// How long after sending a message may still be deleted for everyone
+	DeleteForEveryoneWindow time.Duration
+
+	// Attachments: blob directory, upload size limit, URL signing
+	BlobDir              string
+	MaxAttachmentSize    int64
+	AttachmentSigningKey string
+	AttachmentURLTTL     time.Duration
+
+	// Roughly how many realtime events are kept for gRPC subscribers to resume from
+	EventLogMaxLen int64
+
+	// How often the gRPC server re-checks Postgres and Redis for grpc.health.v1
+	HealthCheckInterval time.Duration
+
+	// Where the HTTP server also serves gRPC: empty for not at all, an
+	// address such as ":50051" for a second listener, or "shared" for the
+	// HTTP port
+	GRPCListen string
+
+	// gRPC TLS: server certificate and key, and the CA whose client
+	// certificates are accepted as service credentials
+	GRPCTLSCert  string
+	GRPCTLSKey   string
+	GRPCClientCA string
+
+	// JWT audience that marks a token as a service credential; empty accepts
+	// only client certificates
+	GRPCServiceAudience string
 }
 
 // func LoadConfig() *Config {
@@ -28,6 +76,66 @@ func LoadConfig() *Config {
 		PostgresDSN: os.Getenv("POSTGRES_DSN"),
 		RedisAddr:   os.Getenv("REDIS_ADDR"),
 		AppEnv:      os.Getenv("APP_ENV"),
+		NodeID:      os.Getenv("NODE_ID"),
+		PresenceTTL: getDuration("PRESENCE_TTL", 30*time.Second),
+
+		PresenceIdleAfter: getDuration("PRESENCE_IDLE_AFTER", 5*time.Minute),
+
+		WSPingInterval:   getDuration("WS_PING_INTERVAL", 54*time.Second),
+		WSPongWait:       getDuration("WS_PONG_WAIT", 60*time.Second),
+		WSWriteWait:      getDuration("WS_WRITE_WAIT", 10*time.Second),
+		WSMaxMessageSize: getInt64("WS_MAX_MESSAGE_SIZE", 64<<10),
+		WSOverflowPolicy: getString("WS_OVERFLOW_POLICY", "disconnect"),
+
+		DeleteForEveryoneWindow: getDuration("DELETE_FOR_EVERYONE_WINDOW", 48*time.Hour),
+
+		BlobDir:              getString("BLOB_DIR", "data/blobs"),
+		MaxAttachmentSize:    getInt64("ATTACHMENT_MAX_BYTES", 25<<20),
+		AttachmentSigningKey: os.Getenv("ATTACHMENT_SIGNING_KEY"),
+		AttachmentURLTTL:     getDuration("ATTACHMENT_URL_TTL", 15*time.Minute),
+
+		EventLogMaxLen: getInt64("EVENT_LOG_MAX_LEN", 100000),
+
+		HealthCheckInterval: getDuration("HEALTH_CHECK_INTERVAL", 10*time.Second),
+
+		GRPCListen:   os.Getenv("GRPC_LISTEN"),
+		GRPCTLSCert:  os.Getenv("GRPC_TLS_CERT"),
+		GRPCTLSKey:   os.Getenv("GRPC_TLS_KEY"),
+		GRPCClientCA: os.Getenv("GRPC_CLIENT_CA"),
+
+		GRPCServiceAudience: os.Getenv("GRPC_SERVICE_AUDIENCE"),
+	}
+
+	// Each instance needs a distinct node ID on the cluster bus
+	if cfg.NodeID == "" {
+		hostname, _ := os.Hostname()
+		cfg.NodeID = fmt.Sprintf("%s-%d", hostname, os.Getpid())
+	}
+
+	// Pings must arrive before the peer's pong wait runs out
+	if cfg.WSPongWait <= 0 {
+		cfg.WSPongWait = 60 * time.Second
+	}
+	if cfg.WSPingInterval <= 0 || cfg.WSPingInterval >= cfg.WSPongWait {
+		cfg.WSPingInterval = cfg.WSPongWait * 9 / 10
+		log.Printf("WS_PING_INTERVAL must be below WS_PONG_WAIT, using %s", cfg.WSPingInterval)
+	}
+
+	// A read limit of 0 or less would turn the frame size limit off
+	if cfg.WSMaxMessageSize <= 0 {
+		log.Printf("WS_MAX_MESSAGE_SIZE must be positive, using %d", 64<<10)
+		cfg.WSMaxMessageSize = 64 << 10
+	}
+
+	// The presence heartbeat runs every third of the TTL, and Redis expiries
+	// have millisecond resolution
+	if cfg.PresenceTTL < time.Second {
+		log.Printf("PRESENCE_TTL must be at least 1s, using %s", 30*time.Second)
+		cfg.PresenceTTL = 30 * time.Second
+	}
+
+	if cfg.HealthCheckInterval <= 0 {
+		cfg.HealthCheckInterval = 10 * time.Second
 	}
 
 	if cfg.PostgresDSN == "" {
@@ -36,3 +144,39 @@ func LoadConfig() *Config {
 
 	return cfg
 }
+
+// getDuration reads a duration such as "15m" or "48h" from the environment
+func getDuration(key string, fallback time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Printf("invalid %s %q, using %s", key, v, fallback)
+		return fallback
+	}
+	return d
+}
+
+// getString reads a string from the environment with a default
+func getString(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// getInt64 reads an integer such as a byte count from the environment
+func getInt64(key string, fallback int64) int64 {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		log.Printf("invalid %s %q, using %d", key, v, fallback)
+		return fallback
+	}
+	return n
+}
@@ -0,0 +1,125 @@
+// Package dbtest runs tests against a throwaway schema in the Postgres
+// database named by TEST_POSTGRES_DSN. Without it, database tests are skipped.
+package dbtest
+
+import (
+	"database/sql"
+	"fmt"
+	"messaging-service/internal/config"
+	"messaging-service/internal/db"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+// usersTable stands in for the users table, which the service reads but does
+// not create
+const usersTable = `CREATE TABLE users (
+	id    SERIAL PRIMARY KEY,
+	name  TEXT NOT NULL,
+	email TEXT NOT NULL DEFAULT '',
+	role  INT NOT NULL DEFAULT 0
+)`
+
+// Main runs a package's tests with db.DB pointing at a fresh, migrated schema
+// that is dropped afterwards. Call it from TestMain.
+func Main(m *testing.M) int {
+	dsn := os.Getenv("TEST_POSTGRES_DSN")
+	if dsn == "" {
+		return m.Run()
+	}
+
+	admin, err := sql.Open("postgres", dsn)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "dbtest:", err)
+		return 1
+	}
+	defer admin.Close()
+
+	schema := fmt.Sprintf("test_%d_%d", os.Getpid(), time.Now().UnixNano())
+	if _, err := admin.Exec("CREATE SCHEMA " + schema); err != nil {
+		fmt.Fprintln(os.Stderr, "dbtest:", err)
+		return 1
+	}
+	defer admin.Exec("DROP SCHEMA " + schema + " CASCADE")
+
+	if err := db.Init(&config.Config{PostgresDSN: withSearchPath(dsn, schema)}); err != nil {
+		fmt.Fprintln(os.Stderr, "dbtest:", err)
+		return 1
+	}
+	defer db.DB.Close()
+
+	if _, err := db.DB.Exec(usersTable); err != nil {
+		fmt.Fprintln(os.Stderr, "dbtest:", err)
+		return 1
+	}
+	if err := db.Migrate(); err != nil {
+		fmt.Fprintln(os.Stderr, "dbtest: migrate:", err)
+		return 1
+	}
+	return m.Run()
+}
+
+// withSearchPath makes every connection of the DSN use the schema
+func withSearchPath(dsn, schema string) string {
+	if !strings.Contains(dsn, "://") {
+		return dsn + " search_path=" + schema
+	}
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return dsn
+	}
+	q := u.Query()
+	q.Set("search_path", schema)
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// Require skips the test unless a test database is configured
+func Require(t testing.TB) {
+	t.Helper()
+	if db.DB == nil {
+		t.Skip("TEST_POSTGRES_DSN not set")
+	}
+}
+
+// CreateUser inserts a user and returns its ID in the string form used by
+// messages and conversations
+func CreateUser(t testing.TB, name string) string {
+	t.Helper()
+	var id int
+	err := db.DB.QueryRow(`INSERT INTO users (name, email) VALUES ($1, $1 || '@example.com') RETURNING id`, name).Scan(&id)
+	if err != nil {
+		t.Fatalf("create user %s: %v", name, err)
+	}
+	return strconv.Itoa(id)
+}
+
+// Unreachable points db.DB at an address that refuses connections for the
+// rest of the test, as when Postgres is down
+func Unreachable(t testing.TB) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	down, err := sql.Open("postgres", "postgres://test@"+addr+"/test?sslmode=disable&connect_timeout=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := db.DB
+	db.DB = down
+	t.Cleanup(func() {
+		db.DB = prev
+		down.Close()
+	})
+}
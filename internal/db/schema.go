@@ -0,0 +1,150 @@
+package db
+
+// schema holds the idempotent DDL applied on startup. Statements run in order,
+// so new tables and columns should be appended at the end.
+var schema = []string{
+	`CREATE TABLE IF NOT EXISTS messages (
+		id          SERIAL PRIMARY KEY,
+		sender_id   TEXT NOT NULL,
+		receiver_id TEXT,
+		content     TEXT NOT NULL,
+		status      TEXT NOT NULL DEFAULT 'sent',
+		created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	)`,
+
+	// Conversations: direct (1:1), group and channel
+	`CREATE TABLE IF NOT EXISTS conversations (
+		id         SERIAL PRIMARY KEY,
+		type       TEXT NOT NULL CHECK (type IN ('direct', 'group', 'channel')),
+		name       TEXT NOT NULL DEFAULT '',
+		direct_key TEXT UNIQUE,
+		created_by TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	)`,
+	`CREATE TABLE IF NOT EXISTS conversation_members (
+		conversation_id INT NOT NULL REFERENCES conversations(id) ON DELETE CASCADE,
+		user_id         TEXT NOT NULL,
+		role            TEXT NOT NULL DEFAULT 'member',
+		joined_at       TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		PRIMARY KEY (conversation_id, user_id)
+	)`,
+	`CREATE INDEX IF NOT EXISTS conversation_members_user_idx ON conversation_members (user_id)`,
+	`ALTER TABLE messages ALTER COLUMN receiver_id DROP NOT NULL`,
+	`ALTER TABLE messages ADD COLUMN IF NOT EXISTS conversation_id INT REFERENCES conversations(id)`,
+
+	// Backfill direct conversations for messages written before conversations existed
+	`INSERT INTO conversations (type, direct_key)
+		SELECT DISTINCT 'direct', LEAST(sender_id, receiver_id) || ':' || GREATEST(sender_id, receiver_id)
+		FROM messages
+		WHERE conversation_id IS NULL AND receiver_id IS NOT NULL AND receiver_id <> ''
+		ON CONFLICT (direct_key) DO NOTHING`,
+	`INSERT INTO conversation_members (conversation_id, user_id)
+		SELECT id, split_part(direct_key, ':', 1) FROM conversations WHERE type = 'direct'
+		UNION
+		SELECT id, split_part(direct_key, ':', 2) FROM conversations WHERE type = 'direct'
+		ON CONFLICT DO NOTHING`,
+	`UPDATE messages m
+		SET conversation_id = c.id
+		FROM conversations c
+		WHERE m.conversation_id IS NULL
+			AND c.direct_key = LEAST(m.sender_id, m.receiver_id) || ':' || GREATEST(m.sender_id, m.receiver_id)`,
+
+	// Per-recipient delivery receipts; pending rows form the offline queue
+	`CREATE TABLE IF NOT EXISTS message_receipts (
+		message_id   INT NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+		user_id      TEXT NOT NULL,
+		delivered_at TIMESTAMPTZ,
+		PRIMARY KEY (message_id, user_id)
+	)`,
+	`CREATE INDEX IF NOT EXISTS message_receipts_undelivered_idx
+		ON message_receipts (user_id, message_id) WHERE delivered_at IS NULL`,
+	`ALTER TABLE message_receipts ADD COLUMN IF NOT EXISTS read_at TIMESTAMPTZ`,
+
+	// Client-generated idempotency keys, unique per sender
+	`ALTER TABLE messages ADD COLUMN IF NOT EXISTS client_message_id TEXT`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS messages_sender_client_message_idx
+		ON messages (sender_id, client_message_id) WHERE client_message_id IS NOT NULL`,
+
+	// Stable (created_at, id) ordering for cursor pagination of history
+	`CREATE INDEX IF NOT EXISTS messages_conversation_created_idx
+		ON messages (conversation_id, created_at DESC, id DESC)`,
+	// Superseded by the index above; dropped where older deployments made it
+	`DROP INDEX IF EXISTS messages_conversation_idx`,
+
+	// Full-text search over message content
+	`ALTER TABLE messages ADD COLUMN IF NOT EXISTS content_tsv tsvector
+		GENERATED ALWAYS AS (to_tsvector('english', coalesce(content, ''))) STORED`,
+	`CREATE INDEX IF NOT EXISTS messages_content_tsv_idx ON messages USING GIN (content_tsv)`,
+
+	// Message edits: audit trail of previous versions
+	`ALTER TABLE messages ADD COLUMN IF NOT EXISTS edited_at TIMESTAMPTZ`,
+	`CREATE TABLE IF NOT EXISTS message_edits (
+		id               SERIAL PRIMARY KEY,
+		message_id       INT NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+		previous_content TEXT NOT NULL,
+		edited_by        TEXT NOT NULL,
+		edited_at        TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	)`,
+	`CREATE INDEX IF NOT EXISTS message_edits_message_idx ON message_edits (message_id, edited_at)`,
+
+	// Deletes: tombstones for "delete for everyone", per-user hides for "delete for me"
+	`ALTER TABLE messages ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ`,
+	`ALTER TABLE messages ADD COLUMN IF NOT EXISTS deleted_by TEXT`,
+	`CREATE TABLE IF NOT EXISTS message_hidden (
+		message_id INT NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+		user_id    TEXT NOT NULL,
+		hidden_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		PRIMARY KEY (user_id, message_id)
+	)`,
+
+	// Emoji reactions
+	`CREATE TABLE IF NOT EXISTS message_reactions (
+		message_id INT NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+		user_id    TEXT NOT NULL,
+		emoji      TEXT NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		PRIMARY KEY (message_id, user_id, emoji)
+	)`,
+
+	// Threads: replies point at their root, which keeps reply stats
+	`ALTER TABLE messages ADD COLUMN IF NOT EXISTS parent_id INT REFERENCES messages(id)`,
+	`ALTER TABLE messages ADD COLUMN IF NOT EXISTS reply_count INT NOT NULL DEFAULT 0`,
+	`ALTER TABLE messages ADD COLUMN IF NOT EXISTS last_reply_at TIMESTAMPTZ`,
+	`CREATE INDEX IF NOT EXISTS messages_thread_idx ON messages (parent_id, created_at DESC, id DESC)
+		WHERE parent_id IS NOT NULL`,
+	`CREATE INDEX IF NOT EXISTS messages_conversation_roots_idx ON messages (conversation_id, created_at DESC, id DESC)
+		WHERE parent_id IS NULL`,
+
+	// Attachments: uploaded to a conversation, linked to a message when sent
+	`CREATE TABLE IF NOT EXISTS attachments (
+		id              SERIAL PRIMARY KEY,
+		message_id      INT REFERENCES messages(id) ON DELETE CASCADE,
+		conversation_id INT NOT NULL REFERENCES conversations(id) ON DELETE CASCADE,
+		uploader_id     TEXT NOT NULL,
+		filename        TEXT NOT NULL,
+		content_type    TEXT NOT NULL,
+		size            BIGINT NOT NULL,
+		width           INT,
+		height          INT,
+		storage_key     TEXT NOT NULL,
+		thumbnail_key   TEXT,
+		created_at      TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	)`,
+	`CREATE INDEX IF NOT EXISTS attachments_message_idx ON attachments (message_id)`,
+
+	// Presence: when each user was last connected
+	`CREATE TABLE IF NOT EXISTS user_presence (
+		user_id      TEXT PRIMARY KEY,
+		last_seen_at TIMESTAMPTZ NOT NULL
+	)`,
+}
+
+// Migrate applies the schema to the connected database
+func Migrate() error {
+	for _, stmt := range schema {
+		if _, err := DB.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
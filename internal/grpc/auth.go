@@ -0,0 +1,262 @@
+package grpc
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"messaging-service/internal/auth"
+	"messaging-service/internal/config"
+	"messaging-service/internal/model"
+	pb "messaging-service/messaging-service/gen/messagepb"
+	"os"
+	"slices"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// Caller roles. End users get theirs from their users.role (see userRoles);
+// backend services authenticate with a client certificate or with a JWT for
+// the service audience, and only they may act for other users.
+const (
+	RoleUser    = "user"
+	RoleAdmin   = "admin"
+	RoleService = "service"
+)
+
+// userRoles maps users.role to the role of an end user on the gRPC API.
+// Values missing here get RoleUser.
+var userRoles = map[int]string{
+	model.UserRoleMember: RoleUser,
+	model.UserRoleAdmin:  RoleAdmin,
+}
+
+// userRole returns the gRPC role of a users.role value
+func userRole(role int) string {
+	if r, ok := userRoles[role]; ok {
+		return r
+	}
+	return RoleUser
+}
+
+// Caller is the authenticated identity behind an RPC
+type Caller struct {
+	ID   string
+	Role string
+}
+
+// IsService reports whether the caller is a backend service, which may act
+// for any user
+func (c *Caller) IsService() bool {
+	return c.Role == RoleService
+}
+
+// methodRoles lists the roles allowed to call each method. A nil entry makes
+// the method public; methods missing from the table are denied.
+var methodRoles = map[string][]string{
+	pb.MessageService_Health_FullMethodName:            nil,
+	pb.MessageService_SendMessage_FullMethodName:       {RoleUser, RoleAdmin, RoleService},
+	pb.MessageService_GetHistory_FullMethodName:        {RoleUser, RoleAdmin, RoleService},
+	pb.MessageService_ListConversations_FullMethodName: {RoleUser, RoleAdmin, RoleService},
+	pb.MessageService_GetPresence_FullMethodName:       {RoleUser, RoleAdmin, RoleService},
+	pb.MessageService_MarkRead_FullMethodName:          {RoleUser, RoleAdmin, RoleService},
+	pb.MessageService_Subscribe_FullMethodName:         {RoleAdmin, RoleService},
+
+	healthpb.Health_Check_FullMethodName: nil,
+	healthpb.Health_Watch_FullMethodName: nil,
+	healthpb.Health_List_FullMethodName:  nil,
+
+	"/grpc.reflection.v1.ServerReflection/ServerReflectionInfo":      {RoleAdmin, RoleService},
+	"/grpc.reflection.v1alpha.ServerReflection/ServerReflectionInfo": {RoleAdmin, RoleService},
+}
+
+type callerKey struct{}
+
+// CallerFromContext returns the caller authenticated by the interceptors, or
+// nil for public methods
+func CallerFromContext(ctx context.Context) *Caller {
+	c, _ := ctx.Value(callerKey{}).(*Caller)
+	return c
+}
+
+// Authenticator verifies the credentials of every RPC and enforces
+// methodRoles. Callers present either "authorization: Bearer <jwt>" metadata,
+// checked like the REST API's tokens, or a client certificate verified by the
+// server's TLS config, which identifies a service by its common name. A token
+// is a service credential only when its audience includes serviceAudience;
+// otherwise its subject must be a user, whose role is read from the users
+// table (the token's role claim is not trusted).
+type Authenticator struct {
+	pubKey          *rsa.PublicKey
+	serviceAudience string
+	userRole        func(userID string) (int, error)
+}
+
+func NewAuthenticator(pubKey *rsa.PublicKey, serviceAudience string) *Authenticator {
+	return &Authenticator{pubKey: pubKey, serviceAudience: serviceAudience, userRole: model.GetUserRole}
+}
+
+// UnaryInterceptor authenticates and authorizes unary RPCs
+func (a *Authenticator) UnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := a.authorize(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamInterceptor authenticates and authorizes streaming RPCs
+func (a *Authenticator) StreamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := a.authorize(ss.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &authorizedStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// authorizedStream carries the caller in the stream's context
+type authorizedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authorizedStream) Context() context.Context {
+	return s.ctx
+}
+
+// authorize identifies the caller and checks its role may call the method
+func (a *Authenticator) authorize(ctx context.Context, method string) (context.Context, error) {
+	roles, ok := methodRoles[method]
+	if !ok {
+		return nil, status.Error(codes.PermissionDenied, "method not allowed")
+	}
+	if roles == nil {
+		return ctx, nil
+	}
+
+	caller, err := a.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, role := range roles {
+		if caller.Role == role {
+			return context.WithValue(ctx, callerKey{}, caller), nil
+		}
+	}
+	return nil, status.Errorf(codes.PermissionDenied, "role %q may not call this method", caller.Role)
+}
+
+// authenticate reads the caller from a bearer token, falling back to a
+// verified client certificate
+func (a *Authenticator) authenticate(ctx context.Context) (*Caller, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get("authorization"); len(values) > 0 {
+		tokenStr, found := strings.CutPrefix(values[0], "Bearer ")
+		if !found {
+			return nil, status.Error(codes.Unauthenticated, "invalid authorization metadata format")
+		}
+		claims, err := auth.VerifyToken(tokenStr, a.pubKey)
+		if err != nil {
+			return nil, status.Error(codes.Unauthenticated, "invalid token: "+err.Error())
+		}
+		if claims.Subject == "" {
+			return nil, status.Error(codes.Unauthenticated, "invalid token: missing subject")
+		}
+		if a.serviceAudience != "" && slices.Contains(claims.Audience, a.serviceAudience) {
+			return &Caller{ID: claims.Subject, Role: RoleService}, nil
+		}
+
+		role, err := a.userRole(claims.Subject)
+		if err == model.ErrUnknownUser {
+			return nil, status.Error(codes.Unauthenticated, "invalid token: unknown user")
+		}
+		if err != nil {
+			return nil, internalError("error loading user role", err)
+		}
+		return &Caller{ID: claims.Subject, Role: userRole(role)}, nil
+	}
+
+	if p, ok := peer.FromContext(ctx); ok {
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok && len(info.State.VerifiedChains) > 0 {
+			cert := info.State.VerifiedChains[0][0]
+			if cert.Subject.CommonName != "" {
+				return &Caller{ID: cert.Subject.CommonName, Role: RoleService}, nil
+			}
+		}
+	}
+	return nil, status.Error(codes.Unauthenticated, "missing credentials")
+}
+
+// actingUser returns the user an RPC acts for. Services name it in the
+// request; end users may only act for themselves and may leave it empty.
+func actingUser(ctx context.Context, requested, field string) (string, error) {
+	caller := CallerFromContext(ctx)
+	if caller == nil || caller.IsService() {
+		if requested == "" {
+			return "", status.Error(codes.InvalidArgument, field+" required")
+		}
+		return requested, nil
+	}
+	if requested != "" && requested != caller.ID {
+		return "", status.Error(codes.PermissionDenied, "cannot act for another user")
+	}
+	return caller.ID, nil
+}
+
+// ServerCredentials returns TLS credentials for the gRPC server. With a client
+// CA, client certificates signed by it are verified and accepted as service
+// credentials; clients without one can still authenticate with a token.
+func ServerCredentials(certFile, keyFile, clientCAFile string) (credentials.TransportCredentials, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+
+	if clientCAFile != "" {
+		pem, err := os.ReadFile(clientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", clientCAFile)
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return credentials.NewTLS(cfg), nil
+}
+
+// ServerOptions returns the auth interceptors and, when GRPC_TLS_CERT and
+// GRPC_TLS_KEY are set, the TLS credentials for a gRPC server
+func ServerOptions(cfg *config.Config, pubKey *rsa.PublicKey) ([]grpc.ServerOption, error) {
+	a := NewAuthenticator(pubKey, cfg.GRPCServiceAudience)
+	opts := []grpc.ServerOption{
+		grpc.UnaryInterceptor(a.UnaryInterceptor()),
+		grpc.StreamInterceptor(a.StreamInterceptor()),
+	}
+
+	if cfg.GRPCTLSCert != "" || cfg.GRPCTLSKey != "" {
+		creds, err := ServerCredentials(cfg.GRPCTLSCert, cfg.GRPCTLSKey, cfg.GRPCClientCA)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, grpc.Creds(creds))
+	} else if cfg.GRPCClientCA != "" {
+		return nil, fmt.Errorf("GRPC_CLIENT_CA requires GRPC_TLS_CERT and GRPC_TLS_KEY")
+	}
+	return opts, nil
+}
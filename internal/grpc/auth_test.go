@@ -0,0 +1,216 @@
+package grpc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"messaging-service/internal/auth"
+	"messaging-service/internal/model"
+	pb "messaging-service/messaging-service/gen/messagepb"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+const testServiceAudience = "messaging-services"
+
+// testUsers stands in for users.role
+var testUsers = map[string]int{
+	"1": model.UserRoleMember,
+	"2": model.UserRoleAdmin,
+	"3": 7, // a role without a gRPC mapping
+}
+
+func newTestAuthenticator(t *testing.T, serviceAudience string) (*Authenticator, *rsa.PrivateKey) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := NewAuthenticator(&key.PublicKey, serviceAudience)
+	a.userRole = func(userID string) (int, error) {
+		role, ok := testUsers[userID]
+		if !ok {
+			return 0, model.ErrUnknownUser
+		}
+		return role, nil
+	}
+	return a, key
+}
+
+// token signs a JWT for subject, with the given role claim and audience
+func token(t *testing.T, key *rsa.PrivateKey, subject, role string, audience ...string) string {
+	t.Helper()
+	claims := auth.CustomClaims{
+		Role: role,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   subject,
+			Audience:  audience,
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+	}
+	s, err := jwt.NewWithClaims(jwt.SigningMethodRS256, claims).SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func withToken(tok string) context.Context {
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+tok))
+}
+
+// withClientCert returns a context whose peer presented a verified client
+// certificate for commonName
+func withClientCert(commonName string) context.Context {
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: commonName}}
+	info := credentials.TLSInfo{State: tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}}
+	return peer.NewContext(context.Background(), &peer.Peer{AuthInfo: info})
+}
+
+// callUnary runs the unary interceptor for method and returns the caller the
+// handler saw
+func callUnary(a *Authenticator, ctx context.Context, method string) (*Caller, error) {
+	var caller *Caller
+	_, err := a.UnaryInterceptor()(ctx, nil, &grpc.UnaryServerInfo{FullMethod: method},
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			caller = CallerFromContext(ctx)
+			return nil, nil
+		})
+	return caller, err
+}
+
+func TestAuthorizeRejectsMissingAndInvalidCredentials(t *testing.T) {
+	a, key := newTestAuthenticator(t, testServiceAudience)
+	_, otherKey := newTestAuthenticator(t, testServiceAudience)
+	send := pb.MessageService_SendMessage_FullMethodName
+
+	for name, tc := range map[string]struct {
+		ctx    context.Context
+		method string
+		code   codes.Code
+	}{
+		"no credentials":   {context.Background(), send, codes.Unauthenticated},
+		"not bearer":       {metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Token x")), send, codes.Unauthenticated},
+		"wrong key":        {withToken(token(t, otherKey, "1", "")), send, codes.Unauthenticated},
+		"unknown user":     {withToken(token(t, key, "42", "")), send, codes.Unauthenticated},
+		"no subject":       {withToken(token(t, key, "", "")), send, codes.Unauthenticated},
+		"unlisted method":  {withToken(token(t, key, "2", "")), "/message.v1.MessageService/DropTables", codes.PermissionDenied},
+		"member subscribe": {withToken(token(t, key, "1", "")), pb.MessageService_Subscribe_FullMethodName, codes.PermissionDenied},
+	} {
+		if _, err := callUnary(a, tc.ctx, tc.method); status.Code(err) != tc.code {
+			t.Errorf("%s: got %v, want %s", name, err, tc.code)
+		}
+	}
+}
+
+func TestPublicMethodsNeedNoCredentials(t *testing.T) {
+	a, _ := newTestAuthenticator(t, testServiceAudience)
+	for _, method := range []string{pb.MessageService_Health_FullMethodName, healthpb.Health_Check_FullMethodName} {
+		caller, err := callUnary(a, context.Background(), method)
+		if err != nil || caller != nil {
+			t.Errorf("%s: caller %v, err %v", method, caller, err)
+		}
+	}
+}
+
+func TestUserRolesComeFromTheUsersTable(t *testing.T) {
+	a, key := newTestAuthenticator(t, testServiceAudience)
+
+	for userID, want := range map[string]string{"1": RoleUser, "2": RoleAdmin, "3": RoleUser} {
+		// The role claim is not trusted, whatever it says
+		caller, err := callUnary(a, withToken(token(t, key, userID, RoleService)), pb.MessageService_SendMessage_FullMethodName)
+		if err != nil {
+			t.Fatalf("user %s: %v", userID, err)
+		}
+		if caller.ID != userID || caller.Role != want || caller.IsService() {
+			t.Errorf("user %s: caller %+v, want role %s", userID, *caller, want)
+		}
+	}
+
+	if _, err := callUnary(a, withToken(token(t, key, "2", "")), pb.MessageService_Subscribe_FullMethodName); err != nil {
+		t.Errorf("admin Subscribe: %v", err)
+	}
+}
+
+func TestOnlyServicesActForOtherUsers(t *testing.T) {
+	a, key := newTestAuthenticator(t, testServiceAudience)
+	send := pb.MessageService_SendMessage_FullMethodName
+
+	// An end user acts for themselves only
+	userCtx, err := a.authorize(withToken(token(t, key, "1", RoleService)), send)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, err := actingUser(userCtx, "", "sender_id"); err != nil || id != "1" {
+		t.Errorf("own ID: got %q, %v", id, err)
+	}
+	if _, err := actingUser(userCtx, "2", "sender_id"); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("acting for another user: got %v, want PermissionDenied", err)
+	}
+
+	// Services authenticate with the service audience or a client certificate
+	for name, ctx := range map[string]context.Context{
+		"service token": withToken(token(t, key, "billing", "", testServiceAudience)),
+		"client cert":   withClientCert("billing"),
+	} {
+		serviceCtx, err := a.authorize(ctx, send)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if id, err := actingUser(serviceCtx, "2", "sender_id"); err != nil || id != "2" {
+			t.Errorf("%s acting for user 2: got %q, %v", name, id, err)
+		}
+		if _, err := actingUser(serviceCtx, "", "sender_id"); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("%s without sender_id: got %v, want InvalidArgument", name, err)
+		}
+	}
+
+	// Without a configured service audience, no token is a service token
+	noAudience, key := newTestAuthenticator(t, "")
+	ctx := withToken(token(t, key, "billing", "", testServiceAudience))
+	if _, err := noAudience.authorize(ctx, send); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("audience token without GRPC_SERVICE_AUDIENCE: got %v, want Unauthenticated", err)
+	}
+}
+
+// testStream is a server stream carrying only a context
+type testStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *testStream) Context() context.Context { return s.ctx }
+
+func TestStreamInterceptorCarriesCaller(t *testing.T) {
+	a, key := newTestAuthenticator(t, testServiceAudience)
+	info := &grpc.StreamServerInfo{FullMethod: pb.MessageService_Subscribe_FullMethodName}
+
+	var caller *Caller
+	handler := func(srv interface{}, ss grpc.ServerStream) error {
+		caller = CallerFromContext(ss.Context())
+		return nil
+	}
+	if err := a.StreamInterceptor()(nil, &testStream{ctx: withClientCert("search")}, info, handler); err != nil {
+		t.Fatal(err)
+	}
+	if caller == nil || caller.ID != "search" || !caller.IsService() {
+		t.Fatalf("handler saw caller %+v", caller)
+	}
+
+	err := a.StreamInterceptor()(nil, &testStream{ctx: withToken(token(t, key, "1", ""))}, info, handler)
+	if status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("member Subscribe stream: got %v, want PermissionDenied", err)
+	}
+}
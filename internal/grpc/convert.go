@@ -0,0 +1,113 @@
+package grpc
+
+import (
+	"messaging-service/internal/model"
+	"messaging-service/internal/redis"
+	pb "messaging-service/messaging-service/gen/messagepb"
+	"time"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// optionalTimestamp converts a nullable time, keeping nil as unset
+func optionalTimestamp(t *time.Time) *timestamppb.Timestamp {
+	if t == nil {
+		return nil
+	}
+	return timestamppb.New(*t)
+}
+
+func toProtoAttachments(attachments []model.Attachment) []*pb.Attachment {
+	var out []*pb.Attachment
+	for _, a := range attachments {
+		out = append(out, &pb.Attachment{
+			Id:           int64(a.ID),
+			Filename:     a.Filename,
+			ContentType:  a.ContentType,
+			Size:         a.Size,
+			Width:        int32(a.Width),
+			Height:       int32(a.Height),
+			Url:          a.URL,
+			ThumbnailUrl: a.ThumbnailURL,
+		})
+	}
+	return out
+}
+
+func toProtoMessage(m *model.Message) *pb.Message {
+	return &pb.Message{
+		Id:              int64(m.ID),
+		ConversationId:  int64(m.ConversationID),
+		SenderId:        m.SenderID,
+		ReceiverId:      m.ReceiverID,
+		Content:         m.Content,
+		Status:          m.Status,
+		CreatedAt:       timestamppb.New(m.CreatedAt),
+		ClientMessageId: m.ClientMessageID,
+		EditedAt:        optionalTimestamp(m.EditedAt),
+		DeletedAt:       optionalTimestamp(m.DeletedAt),
+		ParentId:        int64(m.ParentID),
+		ReplyCount:      int32(m.ReplyCount),
+		LastReplyAt:     optionalTimestamp(m.LastReplyAt),
+		Attachments:     toProtoAttachments(m.Attachments),
+	}
+}
+
+func toProtoHistoryMessage(m *model.MessageWithUser) *pb.Message {
+	msg := &pb.Message{
+		Id:             int64(m.ID),
+		ConversationId: int64(m.ConversationID),
+		SenderId:       m.SenderID,
+		ReceiverId:     m.ReceiverID,
+		Content:        m.Content,
+		Status:         m.Status,
+		CreatedAt:      timestamppb.New(m.CreatedAt),
+		EditedAt:       optionalTimestamp(m.EditedAt),
+		DeletedAt:      optionalTimestamp(m.DeletedAt),
+		ParentId:       int64(m.ParentID),
+		ReplyCount:     int32(m.ReplyCount),
+		LastReplyAt:    optionalTimestamp(m.LastReplyAt),
+		Attachments:    toProtoAttachments(m.Attachments),
+		SenderName:     m.SenderName,
+	}
+	for _, r := range m.Reactions {
+		msg.Reactions = append(msg.Reactions, &pb.ReactionSummary{
+			Emoji:   r.Emoji,
+			Count:   int32(r.Count),
+			Reacted: r.Reacted,
+		})
+	}
+	return msg
+}
+
+func toProtoConversation(c *model.Conversation) *pb.Conversation {
+	return &pb.Conversation{
+		Id:        int64(c.ID),
+		Type:      c.Type,
+		Name:      c.Name,
+		CreatedBy: c.CreatedBy,
+		CreatedAt: timestamppb.New(c.CreatedAt),
+		MemberIds: c.MemberIDs,
+	}
+}
+
+func toProtoPresence(p redis.Presence) *pb.Presence {
+	return &pb.Presence{
+		UserId:          p.UserID,
+		State:           p.State,
+		StatusText:      p.Text,
+		StatusExpiresAt: optionalTimestamp(p.TextExpiresAt),
+		LastSeenAt:      optionalTimestamp(p.LastSeenAt),
+	}
+}
+
+func toProtoEvent(e *redis.Event) *pb.Event {
+	return &pb.Event{
+		Sequence:       e.Sequence,
+		Type:           e.Type,
+		ConversationId: int64(e.ConversationID),
+		UserIds:        e.UserIDs,
+		CreatedAt:      timestamppb.New(e.At),
+		Payload:        e.Payload,
+	}
+}
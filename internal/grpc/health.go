@@ -0,0 +1,53 @@
+package grpc
+
+import (
+	"context"
+	"log"
+	"messaging-service/internal/health"
+	pb "messaging-service/messaging-service/gen/messagepb"
+	"time"
+
+	"google.golang.org/grpc"
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// RegisterHealth registers the standard grpc.health.v1.Health service and
+// keeps it up to date by checking the dependencies every interval. Besides the
+// overall status ("") and MessageService, each dependency ("postgres",
+// "redis") has its own service name. The returned server can be shut down to
+// report NOT_SERVING while draining.
+func RegisterHealth(s *grpc.Server, interval time.Duration) *grpchealth.Server {
+	hs := grpchealth.NewServer()
+	healthpb.RegisterHealthServer(s, hs)
+
+	updateHealth(hs)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			updateHealth(hs)
+		}
+	}()
+	return hs
+}
+
+// updateHealth runs the dependency checks and publishes their statuses
+func updateHealth(hs *grpchealth.Server) {
+	results := health.Check(context.Background())
+	for name, err := range results {
+		status := healthpb.HealthCheckResponse_SERVING
+		if err != nil {
+			log.Printf("health check %s failed: %v", name, err)
+			status = healthpb.HealthCheckResponse_NOT_SERVING
+		}
+		hs.SetServingStatus(name, status)
+	}
+
+	status := healthpb.HealthCheckResponse_SERVING
+	if !health.Ready(results) {
+		status = healthpb.HealthCheckResponse_NOT_SERVING
+	}
+	hs.SetServingStatus("", status)
+	hs.SetServingStatus(pb.MessageService_ServiceDesc.ServiceName, status)
+}
@@ -0,0 +1,104 @@
+package grpc
+
+import (
+	"context"
+	"messaging-service/internal/db/dbtest"
+	"messaging-service/internal/redis"
+	pb "messaging-service/messaging-service/gen/messagepb"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	goredis "github.com/go-redis/redis/v8"
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// startRedis points the redis package at an in-process Redis for the test
+func startRedis(t *testing.T) *miniredis.Miniredis {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	prev := redis.Client
+	redis.Client = goredis.NewClient(&goredis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() {
+		redis.Client.Close()
+		redis.Client = prev
+	})
+	return mr
+}
+
+// healthStatuses runs one health update and returns the status of the
+// overall service, MessageService and each dependency
+func healthStatuses(t *testing.T) map[string]healthpb.HealthCheckResponse_ServingStatus {
+	t.Helper()
+	hs := grpchealth.NewServer()
+	updateHealth(hs)
+
+	statuses := map[string]healthpb.HealthCheckResponse_ServingStatus{}
+	for _, service := range []string{"", pb.MessageService_ServiceDesc.ServiceName, "postgres", "redis"} {
+		resp, err := hs.Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+		if err != nil {
+			t.Fatalf("check %q: %v", service, err)
+		}
+		statuses[service] = resp.Status
+	}
+	return statuses
+}
+
+// messageServiceHealth calls the MessageService Health RPC
+func messageServiceHealth(t *testing.T) string {
+	t.Helper()
+	resp, err := (&MessageServer{}).Health(context.Background(), &pb.HealthRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.Status
+}
+
+const (
+	serving    = healthpb.HealthCheckResponse_SERVING
+	notServing = healthpb.HealthCheckResponse_NOT_SERVING
+)
+
+func TestHealthWhenPostgresIsDown(t *testing.T) {
+	mr := startRedis(t)
+	dbtest.Unreachable(t)
+
+	s := healthStatuses(t)
+	if s[""] != notServing || s[pb.MessageService_ServiceDesc.ServiceName] != notServing ||
+		s["postgres"] != notServing || s["redis"] != serving {
+		t.Fatalf("postgres down: %v", s)
+	}
+	if got := messageServiceHealth(t); got != "NOT_SERVING" {
+		t.Fatalf("Health RPC with postgres down: %s", got)
+	}
+
+	mr.Close()
+	if s := healthStatuses(t); s[""] != notServing || s["postgres"] != notServing || s["redis"] != notServing {
+		t.Fatalf("both down: %v", s)
+	}
+}
+
+func TestHealthWhenRedisIsDown(t *testing.T) {
+	dbtest.Require(t)
+	mr := startRedis(t)
+
+	s := healthStatuses(t)
+	for service, status := range s {
+		if status != serving {
+			t.Fatalf("all up: %q is %v", service, status)
+		}
+	}
+	if got := messageServiceHealth(t); got != "SERVING" {
+		t.Fatalf("Health RPC with everything up: %s", got)
+	}
+
+	mr.Close()
+	s = healthStatuses(t)
+	if s[""] != notServing || s[pb.MessageService_ServiceDesc.ServiceName] != notServing ||
+		s["postgres"] != serving || s["redis"] != notServing {
+		t.Fatalf("redis down: %v", s)
+	}
+	if got := messageServiceHealth(t); got != "NOT_SERVING" {
+		t.Fatalf("Health RPC with redis down: %s", got)
+	}
+}
@@ -0,0 +1,54 @@
+package grpc
+
+import (
+	"messaging-service/internal/auth"
+	"messaging-service/internal/config"
+	"messaging-service/internal/websocket"
+	pb "messaging-service/messaging-service/gen/messagepb"
+	"net/http"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/reflection"
+)
+
+// ListenShared is the GRPC_LISTEN value that serves gRPC on the HTTP port
+const ListenShared = "shared"
+
+// NewServer builds a gRPC server with MessageService delivering through hub,
+// the standard health service and reflection, authenticating callers with
+// the JWT public key. Both the standalone gRPC binary and the HTTP server
+// (with GRPC_LISTEN set) use it.
+func NewServer(cfg *config.Config, hub *websocket.Hub) (*grpc.Server, error) {
+	pubKey, err := auth.LoadPublicKey()
+	if err != nil {
+		return nil, err
+	}
+	opts, err := ServerOptions(cfg, pubKey)
+	if err != nil {
+		return nil, err
+	}
+
+	s := grpc.NewServer(opts...)
+	pb.RegisterMessageServiceServer(s, NewMessageServer(hub))
+
+	// standard health service, with a status per dependency
+	RegisterHealth(s, cfg.HealthCheckInterval)
+
+	reflection.Register(s)
+	return s, nil
+}
+
+// SharedHandler routes gRPC requests (HTTP/2 with an application/grpc content
+// type) to s and everything else to next, so both can be served on one port.
+// The HTTP server must accept unencrypted HTTP/2; gRPC's own TLS settings do
+// not apply there.
+func SharedHandler(s *grpc.Server, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor == 2 && strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+			s.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
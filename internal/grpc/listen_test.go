@@ -0,0 +1,105 @@
+package grpc
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// startShared serves a gRPC health service and a plain HTTP mux (with an echo
+// WebSocket at /ws) on one port, the way the server does with
+// GRPC_LISTEN=shared
+func startShared(t *testing.T) *httptest.Server {
+	t.Helper()
+	s := grpc.NewServer()
+	hs := grpchealth.NewServer()
+	hs.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(s, hs)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok " + r.Proto))
+	})
+	upgrader := websocket.Upgrader{}
+	mux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		for {
+			typ, msg, err := c.ReadMessage()
+			if err != nil || c.WriteMessage(typ, msg) != nil {
+				return
+			}
+		}
+	})
+
+	srv := httptest.NewUnstartedServer(SharedHandler(s, mux))
+	protocols := new(http.Protocols)
+	protocols.SetHTTP1(true)
+	protocols.SetUnencryptedHTTP2(true)
+	srv.Config.Protocols = protocols
+	srv.Start()
+	t.Cleanup(func() {
+		srv.Close()
+		s.Stop()
+	})
+	return srv
+}
+
+func TestSharedHandlerRoutesByProtocol(t *testing.T) {
+	srv := startShared(t)
+	addr := strings.TrimPrefix(srv.URL, "http://")
+
+	// gRPC over h2c reaches the gRPC server
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	resp, err := healthpb.NewHealthClient(conn).Check(context.Background(), &healthpb.HealthCheckRequest{})
+	if err != nil || resp.Status != healthpb.HealthCheckResponse_SERVING {
+		t.Fatalf("gRPC health check: %v, %v", resp, err)
+	}
+
+	// Plain HTTP, over HTTP/1.1 and over h2c, reaches the mux
+	h2c := new(http.Protocols)
+	h2c.SetUnencryptedHTTP2(true)
+	for name, client := range map[string]*http.Client{
+		"HTTP/1.1": srv.Client(),
+		"HTTP/2.0": {Transport: &http.Transport{Protocols: h2c}},
+	} {
+		res, err := client.Get(srv.URL + "/healthz")
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		body, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		if res.StatusCode != http.StatusOK || string(body) != "ok "+name {
+			t.Errorf("%s: %d %q", name, res.StatusCode, body)
+		}
+	}
+
+	// WebSocket upgrades reach the mux
+	ws, _, err := websocket.DefaultDialer.Dial("ws://"+addr+"/ws", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	if err := ws.WriteMessage(websocket.TextMessage, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if _, msg, err := ws.ReadMessage(); err != nil || string(msg) != "hello" {
+		t.Fatalf("websocket echo: %q, %v", msg, err)
+	}
+}
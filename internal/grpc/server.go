@@ -2,15 +2,220 @@ package grpc
 
 import (
 	"context"
+	"log"
+	"messaging-service/internal/health"
+	"messaging-service/internal/model"
+	"messaging-service/internal/websocket"
 	pb "messaging-service/messaging-service/gen/messagepb"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
-// MessageServer implements the gRPC service
+// MessageServer implements the gRPC service over the same model layer as the
+// REST API. Deliveries and receipts go through Hub, whose cluster bus reaches
+// WebSocket clients held by other processes. Callers are authenticated by
+// the Authenticator interceptors.
 type MessageServer struct {
 	pb.UnimplementedMessageServiceServer
+	Hub *websocket.Hub
+}
+
+// NewMessageServer creates a server delivering through hub
+func NewMessageServer(hub *websocket.Hub) *MessageServer {
+	return &MessageServer{Hub: hub}
 }
 
-// Health returns a simple health status
+// Health returns SERVING while Postgres and Redis are reachable and
+// NOT_SERVING otherwise. grpc.health.v1.Health reports the same per dependency.
 func (s *MessageServer) Health(ctx context.Context, req *pb.HealthRequest) (*pb.HealthResponse, error) {
+	if !health.Ready(health.Check(ctx)) {
+		return &pb.HealthResponse{Status: "NOT_SERVING"}, nil
+	}
 	return &pb.HealthResponse{Status: "SERVING"}, nil
 }
+
+// SendMessage stores a message and delivers it to the conversation's members
+func (s *MessageServer) SendMessage(ctx context.Context, req *pb.SendMessageRequest) (*pb.SendMessageResponse, error) {
+	senderID, err := actingUser(ctx, req.GetSenderId(), "sender_id")
+	if err != nil {
+		return nil, err
+	}
+	// Services name any sender; messages need a real user on both ends
+	if err := model.ValidateUserIDs(senderID); err != nil {
+		return nil, modelError(err)
+	}
+
+	m := model.Message{
+		ConversationID:  int(req.GetConversationId()),
+		SenderID:        senderID,
+		ReceiverID:      req.GetReceiverId(),
+		Content:         req.GetContent(),
+		Status:          model.StatusSent,
+		ClientMessageID: req.GetClientMessageId(),
+		ParentID:        int(req.GetParentId()),
+	}
+	for _, id := range req.GetAttachmentIds() {
+		m.AttachmentIDs = append(m.AttachmentIDs, int(id))
+	}
+
+	if err := m.ResolveConversation(); err != nil {
+		return nil, modelError(err)
+	}
+
+	created, err := m.Save()
+	if err != nil {
+		return nil, modelError(err)
+	}
+
+	// Retries were already delivered when first stored
+	if created {
+		if err := s.Hub.DeliverMessage(&m, nil); err != nil {
+			log.Println("error delivering message:", err)
+		}
+	}
+
+	return &pb.SendMessageResponse{Message: toProtoMessage(&m), Created: created}, nil
+}
+
+// GetHistory pages a conversation's history as seen by user_id
+func (s *MessageServer) GetHistory(ctx context.Context, req *pb.GetHistoryRequest) (*pb.GetHistoryResponse, error) {
+	userID, err := actingUser(ctx, req.GetUserId(), "user_id")
+	if err != nil {
+		return nil, err
+	}
+
+	conversationID := int(req.GetConversationId())
+	switch {
+	case conversationID != 0:
+		if err := requireMember(conversationID, userID); err != nil {
+			return nil, err
+		}
+	case req.GetWithUserId() != "":
+		conversationID, err = model.FindDirectConversationID(userID, req.GetWithUserId())
+		if err != nil {
+			return nil, internalError("error fetching direct conversation", err)
+		}
+		// Users who never talked have no direct conversation yet
+		if conversationID == 0 {
+			return &pb.GetHistoryResponse{}, nil
+		}
+	default:
+		return nil, status.Error(codes.InvalidArgument, "conversation_id or with_user_id required")
+	}
+
+	q := model.PageQuery{Limit: int(req.GetLimit()), ViewerID: userID}
+	if req.GetBefore() != "" {
+		if q.Before, err = model.DecodeCursor(req.GetBefore()); err != nil {
+			return nil, status.Error(codes.InvalidArgument, "invalid before cursor")
+		}
+	}
+	if req.GetAfter() != "" {
+		if q.After, err = model.DecodeCursor(req.GetAfter()); err != nil {
+			return nil, status.Error(codes.InvalidArgument, "invalid after cursor")
+		}
+	}
+	if q.Before != nil && q.After != nil {
+		return nil, status.Error(codes.InvalidArgument, "before and after cannot be combined")
+	}
+
+	page, err := model.GetConversationPageWithUsers(conversationID, q)
+	if err != nil {
+		return nil, internalError("error fetching messages", err)
+	}
+
+	resp := &pb.GetHistoryResponse{NextCursor: page.NextCursor}
+	for i := range page.Messages {
+		resp.Messages = append(resp.Messages, toProtoHistoryMessage(&page.Messages[i]))
+	}
+	return resp, nil
+}
+
+// ListConversations lists the conversations user_id belongs to
+func (s *MessageServer) ListConversations(ctx context.Context, req *pb.ListConversationsRequest) (*pb.ListConversationsResponse, error) {
+	userID, err := actingUser(ctx, req.GetUserId(), "user_id")
+	if err != nil {
+		return nil, err
+	}
+
+	conversations, err := model.GetConversationsForUser(userID)
+	if err != nil {
+		return nil, internalError("error fetching conversations", err)
+	}
+
+	resp := &pb.ListConversationsResponse{}
+	for i := range conversations {
+		resp.Conversations = append(resp.Conversations, toProtoConversation(&conversations[i]))
+	}
+	return resp, nil
+}
+
+// GetPresence returns the presence of each requested user
+func (s *MessageServer) GetPresence(ctx context.Context, req *pb.GetPresenceRequest) (*pb.GetPresenceResponse, error) {
+	if len(req.GetUserIds()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "user_ids required")
+	}
+
+	resp := &pb.GetPresenceResponse{}
+	for _, userID := range req.GetUserIds() {
+		p, err := websocket.GetPresence(userID)
+		if err != nil {
+			return nil, internalError("error fetching presence", err)
+		}
+		resp.Presences = append(resp.Presences, toProtoPresence(p))
+	}
+	return resp, nil
+}
+
+// MarkRead marks the conversation read by user_id up to message_id and
+// notifies the senders
+func (s *MessageServer) MarkRead(ctx context.Context, req *pb.MarkReadRequest) (*pb.MarkReadResponse, error) {
+	userID, err := actingUser(ctx, req.GetUserId(), "user_id")
+	if err != nil {
+		return nil, err
+	}
+	if req.GetConversationId() == 0 || req.GetMessageId() == 0 {
+		return nil, status.Error(codes.InvalidArgument, "conversation_id and message_id required")
+	}
+	if err := requireMember(int(req.GetConversationId()), userID); err != nil {
+		return nil, err
+	}
+
+	if err := s.Hub.MarkRead(userID, int(req.GetConversationId()), int(req.GetMessageId())); err != nil {
+		return nil, internalError("error marking read", err)
+	}
+	return &pb.MarkReadResponse{}, nil
+}
+
+// requireMember fails with PermissionDenied unless the user belongs to the
+// conversation
+func requireMember(conversationID int, userID string) error {
+	isMember, err := model.IsConversationMember(conversationID, userID)
+	if err != nil {
+		return internalError("error checking membership", err)
+	}
+	if !isMember {
+		return status.Error(codes.PermissionDenied, model.ErrNotConversationMember.Error())
+	}
+	return nil
+}
+
+// modelError maps model validation errors to gRPC status codes
+func modelError(err error) error {
+	switch err {
+	case model.ErrNoConversationTarget, model.ErrInvalidParent, model.ErrMessageDeleted, model.ErrUnknownUser, model.ErrInvalidReceiver,
+		model.ErrClientMessageIDLength, model.ErrInvalidAttachment:
+		return status.Error(codes.InvalidArgument, err.Error())
+	case model.ErrNotConversationMember:
+		return status.Error(codes.PermissionDenied, err.Error())
+	case model.ErrMessageNotFound:
+		return status.Error(codes.NotFound, err.Error())
+	}
+	return internalError("error saving message", err)
+}
+
+// internalError logs the cause and hides it from the caller
+func internalError(msg string, err error) error {
+	log.Println(msg+":", err)
+	return status.Error(codes.Internal, msg)
+}
@@ -0,0 +1,117 @@
+package grpc
+
+import (
+	"messaging-service/internal/redis"
+	pb "messaging-service/messaging-service/gen/messagepb"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Event log reads: how many events per batch, and how long one read blocks
+// before the stream checks whether the client went away
+const (
+	subscribeBatchSize = 100
+	subscribeBlock     = 5 * time.Second
+)
+
+// eventFilter selects the events a subscriber asked for; empty sets match all
+type eventFilter struct {
+	users         map[string]bool
+	conversations map[int]bool
+	types         map[string]bool
+}
+
+func newEventFilter(req *pb.SubscribeRequest) eventFilter {
+	f := eventFilter{}
+	if len(req.GetUserIds()) > 0 {
+		f.users = make(map[string]bool)
+		for _, id := range req.GetUserIds() {
+			f.users[id] = true
+		}
+	}
+	if len(req.GetConversationIds()) > 0 {
+		f.conversations = make(map[int]bool)
+		for _, id := range req.GetConversationIds() {
+			f.conversations[int(id)] = true
+		}
+	}
+	if len(req.GetTypes()) > 0 {
+		f.types = make(map[string]bool)
+		for _, t := range req.GetTypes() {
+			f.types[t] = true
+		}
+	}
+	return f
+}
+
+func (f eventFilter) match(e *redis.Event) bool {
+	if f.types != nil && !f.types[e.Type] {
+		return false
+	}
+	if f.conversations != nil && !f.conversations[e.ConversationID] {
+		return false
+	}
+	if f.users != nil {
+		for _, id := range e.UserIDs {
+			if f.users[id] {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// Subscribe streams events from the event log the Hub records to, starting
+// after after_sequence or, without one, with the next event recorded
+func (s *MessageServer) Subscribe(req *pb.SubscribeRequest, stream grpc.ServerStreamingServer[pb.Event]) error {
+	ctx := stream.Context()
+	filter := newEventFilter(req)
+
+	after := req.GetAfterSequence()
+	if after == 0 {
+		last, err := redis.LastEventSequence()
+		if err != nil {
+			return internalError("error reading event log", err)
+		}
+		after = last
+	} else {
+		err := redis.CheckEventsRetained(after)
+		if err == redis.ErrEventsTrimmed {
+			return status.Error(codes.OutOfRange, err.Error())
+		}
+		if err != nil {
+			return internalError("error reading event log", err)
+		}
+	}
+
+	for ctx.Err() == nil {
+		events, err := redis.ReadEvents(ctx, after, subscribeBatchSize, subscribeBlock)
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			return internalError("error reading event log", err)
+		}
+
+		// Sequences have no holes, so a jump means the reader fell behind the trimming
+		if len(events) > 0 && events[0].Sequence > after+1 {
+			return status.Error(codes.OutOfRange, redis.ErrEventsTrimmed.Error())
+		}
+
+		for i := range events {
+			e := &events[i]
+			after = e.Sequence
+			if !filter.match(e) {
+				continue
+			}
+			if err := stream.Send(toProtoEvent(e)); err != nil {
+				return err
+			}
+		}
+	}
+	return status.FromContextError(ctx.Err()).Err()
+}
@@ -0,0 +1,130 @@
+package grpc
+
+import (
+	"context"
+	"encoding/json"
+	"messaging-service/internal/redis"
+	pb "messaging-service/messaging-service/gen/messagepb"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// eventStream collects what Subscribe sends
+type eventStream struct {
+	grpc.ServerStream
+	ctx  context.Context
+	sent []uint64
+}
+
+func (s *eventStream) Context() context.Context { return s.ctx }
+
+func (s *eventStream) Send(e *pb.Event) error {
+	s.sent = append(s.sent, e.Sequence)
+	return nil
+}
+
+// startEventLog points the redis package at an in-process Redis holding
+// these events, numbered from 1
+func startEventLog(t *testing.T, events ...redis.Event) {
+	t.Helper()
+	startRedis(t)
+	for _, e := range events {
+		e.Payload = json.RawMessage(`{}`)
+		if _, err := redis.AppendEvent(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// subscribe runs Subscribe until its context times out and returns the
+// sequences sent and the error it ended with
+func subscribe(req *pb.SubscribeRequest) ([]uint64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	stream := &eventStream{ctx: ctx}
+	err := (&MessageServer{}).Subscribe(req, stream)
+	return stream.sent, err
+}
+
+func TestSubscribeResumesAndFilters(t *testing.T) {
+	startEventLog(t,
+		redis.Event{Type: "message.new", ConversationID: 1, UserIDs: []string{"1", "2"}},
+		redis.Event{Type: "message.new", ConversationID: 2, UserIDs: []string{"2", "3"}},
+		redis.Event{Type: "typing.start", ConversationID: 1, UserIDs: []string{"1"}},
+		redis.Event{Type: "message.new", ConversationID: 3, UserIDs: []string{"4"}},
+	)
+
+	tests := []struct {
+		name string
+		req  *pb.SubscribeRequest
+		want []uint64
+	}{
+		{"everything after 1", &pb.SubscribeRequest{AfterSequence: 1}, []uint64{2, 3, 4}},
+		{"conversation", &pb.SubscribeRequest{AfterSequence: 1, ConversationIds: []int64{1}}, []uint64{3}},
+		{"user", &pb.SubscribeRequest{AfterSequence: 1, UserIds: []string{"2"}}, []uint64{2}},
+		{"type", &pb.SubscribeRequest{AfterSequence: 1, Types: []string{"typing.start"}}, []uint64{3}},
+		{"all filters", &pb.SubscribeRequest{
+			AfterSequence:   1,
+			UserIds:         []string{"1", "4"},
+			ConversationIds: []int64{1, 3},
+			Types:           []string{"message.new"},
+		}, []uint64{4}},
+		{"no cursor starts with the next event", &pb.SubscribeRequest{}, nil},
+	}
+	for _, tt := range tests {
+		sent, err := subscribe(tt.req)
+		if status.Code(err) != codes.DeadlineExceeded {
+			t.Errorf("%s: ended with %v", tt.name, err)
+		}
+		if len(sent) != len(tt.want) {
+			t.Errorf("%s: sent %v, want %v", tt.name, sent, tt.want)
+			continue
+		}
+		for i := range sent {
+			if sent[i] != tt.want[i] {
+				t.Errorf("%s: sent %v, want %v", tt.name, sent, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestSubscribeFromTrimmedCursor(t *testing.T) {
+	startEventLog(t,
+		redis.Event{Type: "a"}, redis.Event{Type: "b"}, redis.Event{Type: "c"},
+	)
+	if err := redis.Client.XTrimMaxLen(redis.Ctx, "events:log", 1).Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	sent, err := subscribe(&pb.SubscribeRequest{AfterSequence: 1})
+	if status.Code(err) != codes.OutOfRange || len(sent) != 0 {
+		t.Fatalf("cursor behind the log: sent %v, ended with %v, want OutOfRange", sent, err)
+	}
+	sent, err = subscribe(&pb.SubscribeRequest{AfterSequence: 2})
+	if status.Code(err) != codes.DeadlineExceeded || len(sent) != 1 || sent[0] != 3 {
+		t.Fatalf("cursor at the log start: sent %v, ended with %v", sent, err)
+	}
+}
+
+func TestSubscribeWithoutCursorStreamsNewEvents(t *testing.T) {
+	startEventLog(t, redis.Event{Type: "old"})
+
+	done := make(chan []uint64, 1)
+	go func() {
+		sent, _ := subscribe(&pb.SubscribeRequest{})
+		done <- sent
+	}()
+	time.Sleep(50 * time.Millisecond)
+	if _, err := redis.AppendEvent(redis.Event{Type: "new", Payload: json.RawMessage(`{}`)}); err != nil {
+		t.Fatal(err)
+	}
+
+	if sent := <-done; len(sent) != 1 || sent[0] != 2 {
+		t.Fatalf("sent %v, want only the new event 2", sent)
+	}
+}
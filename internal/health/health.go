@@ -0,0 +1,60 @@
+package health
+
+import (
+	"context"
+	"messaging-service/internal/db"
+	"messaging-service/internal/redis"
+	"sync"
+	"time"
+)
+
+// Dependencies checked for readiness
+const (
+	Postgres = "postgres"
+	Redis    = "redis"
+)
+
+// checkTimeout bounds each dependency ping
+const checkTimeout = 2 * time.Second
+
+var checks = map[string]func(ctx context.Context) error{
+	Postgres: func(ctx context.Context) error {
+		return db.DB.PingContext(ctx)
+	},
+	Redis: func(ctx context.Context) error {
+		return redis.Client.Ping(ctx).Err()
+	},
+}
+
+// Check pings every dependency concurrently and returns each one's error,
+// nil when it is reachable
+func Check(ctx context.Context) map[string]error {
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	results := make(map[string]error, len(checks))
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check func(context.Context) error) {
+			defer wg.Done()
+			err := check(ctx)
+			mu.Lock()
+			results[name] = err
+			mu.Unlock()
+		}(name, check)
+	}
+	wg.Wait()
+	return results
+}
+
+// Ready reports whether every dependency in results is reachable
+func Ready(results map[string]error) bool {
+	for _, err := range results {
+		if err != nil {
+			return false
+		}
+	}
+	return true
+}
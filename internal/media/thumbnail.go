@@ -0,0 +1,106 @@
+package media
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	_ "image/gif" // register decoders
+	"image/jpeg"
+	_ "image/png"
+	"io"
+)
+
+// ThumbnailSize bounds the longer side of a thumbnail in pixels
+const ThumbnailSize = 320
+
+// maxSourcePixels rejects images that would take too much memory to decode
+const maxSourcePixels = 40_000_000
+
+var ErrImageTooLarge = errors.New("image dimensions too large")
+
+// CanThumbnail reports whether thumbnails can be generated for a MIME type
+func CanThumbnail(contentType string) bool {
+	switch contentType {
+	case "image/jpeg", "image/png", "image/gif":
+		return true
+	}
+	return false
+}
+
+// DecodeConfig reads the dimensions of an image without decoding it
+func DecodeConfig(r io.Reader) (width, height int, err error) {
+	cfg, _, err := image.DecodeConfig(r)
+	if err != nil {
+		return 0, 0, err
+	}
+	if cfg.Width*cfg.Height > maxSourcePixels {
+		return 0, 0, ErrImageTooLarge
+	}
+	return cfg.Width, cfg.Height, nil
+}
+
+// Thumbnail decodes an image and scales it down to fit in size x size, encoded
+// as JPEG. Transparent areas are flattened onto white. Smaller images keep
+// their dimensions.
+func Thumbnail(r io.Reader, size int) ([]byte, error) {
+	src, _, err := image.Decode(r)
+	if err != nil {
+		return nil, err
+	}
+
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w*h > maxSourcePixels {
+		return nil, ErrImageTooLarge
+	}
+	if w > size || h > size {
+		if w >= h {
+			w, h = size, max(1, h*size/w)
+		} else {
+			w, h = max(1, w*size/h), size
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, scale(src, w, h), &jpeg.Options{Quality: 80}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// scale resizes src to w x h by averaging the source pixels covered by each
+// destination pixel (a box filter)
+func scale(src image.Image, w, h int) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	b := src.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+
+	for y := 0; y < h; y++ {
+		y0 := b.Min.Y + y*sh/h
+		y1 := max(y0+1, b.Min.Y+(y+1)*sh/h)
+		for x := 0; x < w; x++ {
+			x0 := b.Min.X + x*sw/w
+			x1 := max(x0+1, b.Min.X+(x+1)*sw/w)
+
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := src.At(sx, sy).RGBA()
+					r, g, bl, a = r+uint64(pr), g+uint64(pg), bl+uint64(pb), a+uint64(pa)
+					n++
+				}
+			}
+			// Colors are premultiplied, so compositing over white adds the
+			// uncovered fraction to each channel
+			white := 0xffff - a/n
+			dst.Set(x, y, color.RGBA64{
+				R: uint16(r/n + white),
+				G: uint16(g/n + white),
+				B: uint16(bl/n + white),
+				A: 0xffff,
+			})
+		}
+	}
+	return dst
+}
@@ -0,0 +1,105 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+// encodePNG returns a w x h PNG filled with c
+func encodePNG(t *testing.T, w, h int, c color.Color) []byte {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestThumbnailDimensions(t *testing.T) {
+	tests := []struct {
+		w, h, wantW, wantH int
+	}{
+		{640, 480, 320, 240},
+		{480, 640, 240, 320},
+		{1000, 2, 320, 1},
+		{100, 50, 100, 50},
+	}
+	for _, tt := range tests {
+		thumb, err := Thumbnail(bytes.NewReader(encodePNG(t, tt.w, tt.h, color.Black)), ThumbnailSize)
+		if err != nil {
+			t.Fatalf("%dx%d: %v", tt.w, tt.h, err)
+		}
+		cfg, err := jpeg.DecodeConfig(bytes.NewReader(thumb))
+		if err != nil {
+			t.Fatalf("%dx%d: thumbnail is not a JPEG: %v", tt.w, tt.h, err)
+		}
+		if cfg.Width != tt.wantW || cfg.Height != tt.wantH {
+			t.Errorf("%dx%d: thumbnail is %dx%d, want %dx%d", tt.w, tt.h, cfg.Width, cfg.Height, tt.wantW, tt.wantH)
+		}
+	}
+}
+
+func TestThumbnailFlattensTransparencyOntoWhite(t *testing.T) {
+	thumb, err := Thumbnail(bytes.NewReader(encodePNG(t, 8, 8, color.Transparent)), ThumbnailSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := jpeg.Decode(bytes.NewReader(thumb))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, g, b, _ := img.At(4, 4).RGBA()
+	if r < 0xf000 || g < 0xf000 || b < 0xf000 {
+		t.Fatalf("transparent pixel became %x %x %x, want white", r, g, b)
+	}
+}
+
+func TestThumbnailRejectsInvalidImages(t *testing.T) {
+	if _, err := Thumbnail(strings.NewReader("not an image"), ThumbnailSize); err == nil {
+		t.Fatal("thumbnail of a text file succeeded")
+	}
+}
+
+func TestDecodeConfig(t *testing.T) {
+	w, h, err := DecodeConfig(bytes.NewReader(encodePNG(t, 30, 20, color.White)))
+	if err != nil || w != 30 || h != 20 {
+		t.Fatalf("DecodeConfig = %d, %d, %v, want 30, 20", w, h, err)
+	}
+
+	// A header claiming 10000x10000 pixels is refused before decoding
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewGray(image.Rect(0, 0, 1, 1)))
+	huge := buf.Bytes()
+	copy(huge[16:24], []byte{0, 0, 0x27, 0x10, 0, 0, 0x27, 0x10})
+	binary.BigEndian.PutUint32(huge[29:33], crc32.ChecksumIEEE(huge[12:29]))
+	if _, _, err := DecodeConfig(bytes.NewReader(huge)); err != ErrImageTooLarge {
+		t.Fatalf("DecodeConfig of a huge image: %v, want ErrImageTooLarge", err)
+	}
+}
+
+func TestCanThumbnail(t *testing.T) {
+	for contentType, want := range map[string]bool{
+		"image/jpeg":      true,
+		"image/png":       true,
+		"image/gif":       true,
+		"image/webp":      false,
+		"application/pdf": false,
+	} {
+		if got := CanThumbnail(contentType); got != want {
+			t.Errorf("CanThumbnail(%q) = %v, want %v", contentType, got, want)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"messaging-service/internal/db"
+	"messaging-service/internal/storage"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// MaxAttachmentSize is the largest accepted upload in bytes
+var MaxAttachmentSize int64 = 25 << 20
+
+// allowedAttachmentTypes are the sniffed MIME types accepted for upload
+var allowedAttachmentTypes = map[string]bool{
+	"image/jpeg":      true,
+	"image/png":       true,
+	"image/gif":       true,
+	"image/webp":      true,
+	"application/pdf": true,
+	"application/zip": true,
+	"text/plain":      true,
+	"audio/mpeg":      true,
+	"audio/wave":      true,
+	"video/mp4":       true,
+	"video/webm":      true,
+}
+
+var ErrInvalidAttachment = errors.New("attachments must be your own unsent uploads to this conversation")
+
+// Attachment is an uploaded file. It belongs to a conversation from upload on
+// and to a message once sent with it.
+type Attachment struct {
+	ID             int       `db:"id" json:"id"`
+	MessageID      int       `db:"message_id" json:"message_id,omitempty"`
+	ConversationID int       `db:"conversation_id" json:"conversation_id"`
+	UploaderID     string    `db:"uploader_id" json:"uploader_id"`
+	Filename       string    `db:"filename" json:"filename"`
+	ContentType    string    `db:"content_type" json:"content_type"`
+	Size           int64     `db:"size" json:"size"`
+	Width          int       `db:"width" json:"width,omitempty"`
+	Height         int       `db:"height" json:"height,omitempty"`
+	CreatedAt      time.Time `db:"created_at" json:"created_at"`
+	StorageKey     string    `db:"storage_key" json:"-"`
+	ThumbnailKey   string    `db:"thumbnail_key" json:"-"`
+
+	// Signed download URLs, filled in for members of the conversation
+	URL          string `json:"url,omitempty"`
+	ThumbnailURL string `json:"thumbnail_url,omitempty"`
+}
+
+const attachmentColumns = `a.id, COALESCE(a.message_id, 0), a.conversation_id, a.uploader_id, a.filename,
+	a.content_type, a.size, COALESCE(a.width, 0), COALESCE(a.height, 0), a.created_at,
+	a.storage_key, COALESCE(a.thumbnail_key, '')`
+
+func scanAttachment(row scanner, a *Attachment) error {
+	return row.Scan(&a.ID, &a.MessageID, &a.ConversationID, &a.UploaderID, &a.Filename,
+		&a.ContentType, &a.Size, &a.Width, &a.Height, &a.CreatedAt,
+		&a.StorageKey, &a.ThumbnailKey)
+}
+
+// IsAllowedAttachmentType reports whether uploads of a MIME type are accepted
+func IsAllowedAttachmentType(contentType string) bool {
+	return allowedAttachmentTypes[contentType]
+}
+
+// Sign fills in signed download URLs
+func (a *Attachment) Sign() {
+	a.URL = storage.SignedURL(fmt.Sprintf("/api/attachments/%d/content", a.ID))
+	if a.ThumbnailKey != "" {
+		a.ThumbnailURL = storage.SignedURL(fmt.Sprintf("/api/attachments/%d/thumbnail", a.ID))
+	}
+}
+
+// CreateAttachment stores the metadata of an uploaded blob
+func CreateAttachment(a *Attachment) error {
+	query := `
+		INSERT INTO attachments (conversation_id, uploader_id, filename, content_type, size,
+			width, height, storage_key, thumbnail_key, created_at)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, 0), NULLIF($7, 0), $8, NULLIF($9, ''), NOW())
+		RETURNING id, created_at
+	`
+	return db.DB.QueryRow(query, a.ConversationID, a.UploaderID, a.Filename, a.ContentType, a.Size,
+		a.Width, a.Height, a.StorageKey, a.ThumbnailKey).Scan(&a.ID, &a.CreatedAt)
+}
+
+// GetAttachmentByID fetches a single attachment
+func GetAttachmentByID(id int) (*Attachment, error) {
+	query := `SELECT ` + attachmentColumns + ` FROM attachments a WHERE a.id = $1`
+
+	var a Attachment
+	if err := scanAttachment(db.DB.QueryRow(query, id), &a); err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// linkAttachments attaches the message's uploads to it. Every ID must be an
+// unsent upload of the sender to the message's conversation.
+func linkAttachments(tx *sql.Tx, m *Message) error {
+	ids := uniqueInts(m.AttachmentIDs)
+	if len(ids) == 0 {
+		return nil
+	}
+
+	query := `
+		UPDATE attachments a SET message_id = $1
+		WHERE a.id = ANY($2) AND a.uploader_id = $3 AND a.conversation_id = $4 AND a.message_id IS NULL
+		RETURNING ` + attachmentColumns
+	rows, err := tx.Query(query, m.ID, pq.Array(ids), m.SenderID, m.ConversationID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	m.Attachments = nil
+	for rows.Next() {
+		var a Attachment
+		if err := scanAttachment(rows, &a); err != nil {
+			return err
+		}
+		a.Sign()
+		m.Attachments = append(m.Attachments, a)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(m.Attachments) != len(ids) {
+		return ErrInvalidAttachment
+	}
+	return nil
+}
+
+// GetAttachmentsForMessages returns the signed attachments of each message
+func GetAttachmentsForMessages(messageIDs []int) (map[int][]Attachment, error) {
+	attachments := make(map[int][]Attachment)
+	if len(messageIDs) == 0 {
+		return attachments, nil
+	}
+
+	query := `SELECT ` + attachmentColumns + ` FROM attachments a WHERE a.message_id = ANY($1) ORDER BY a.id`
+	rows, err := db.DB.Query(query, pq.Array(messageIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var a Attachment
+		if err := scanAttachment(rows, &a); err != nil {
+			return nil, err
+		}
+		a.Sign()
+		attachments[a.MessageID] = append(attachments[a.MessageID], a)
+	}
+	return attachments, rows.Err()
+}
+
+// attachAttachments fills in the attachments of a page of messages
+func attachAttachments(messages []MessageWithUser) error {
+	ids := make([]int, len(messages))
+	for i := range messages {
+		ids[i] = messages[i].ID
+	}
+
+	attachments, err := GetAttachmentsForMessages(ids)
+	if err != nil {
+		return err
+	}
+	for i := range messages {
+		messages[i].Attachments = attachments[messages[i].ID]
+	}
+	return nil
+}
+
+// loadMessageAttachments fills in the attachments of plain messages
+func loadMessageAttachments(messages []Message) error {
+	ids := make([]int, len(messages))
+	for i := range messages {
+		ids[i] = messages[i].ID
+	}
+
+	attachments, err := GetAttachmentsForMessages(ids)
+	if err != nil {
+		return err
+	}
+	for i := range messages {
+		messages[i].Attachments = attachments[messages[i].ID]
+	}
+	return nil
+}
+
+func uniqueInts(values []int) []int {
+	seen := make(map[int]bool, len(values))
+	var out []int
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// deleteAttachments removes the attachment rows of a message and returns the
+// blob keys to delete once the transaction commits
+func deleteAttachments(tx *sql.Tx, messageID int) ([]string, error) {
+	rows, err := tx.Query(`
+		DELETE FROM attachments WHERE message_id = $1
+		RETURNING storage_key, COALESCE(thumbnail_key, '')
+	`, messageID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var key, thumbKey string
+		if err := rows.Scan(&key, &thumbKey); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+		if thumbKey != "" {
+			keys = append(keys, thumbKey)
+		}
+	}
+	return keys, rows.Err()
+}
+
+// removeBlobs deletes blobs whose rows are gone; failures only leak storage
+func removeBlobs(keys []string) {
+	for _, key := range keys {
+		if err := storage.Store.Delete(context.Background(), key); err != nil {
+			log.Println("error deleting blob:", err)
+		}
+	}
+}
@@ -0,0 +1,343 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"messaging-service/internal/db"
+	"time"
+)
+
+// Conversation types
+const (
+	ConversationDirect  = "direct"
+	ConversationGroup   = "group"
+	ConversationChannel = "channel"
+)
+
+// Member roles. Owners and admins manage a group's or channel's members.
+const (
+	MemberRoleOwner  = "owner"
+	MemberRoleAdmin  = "admin"
+	MemberRoleMember = "member"
+)
+
+var ErrLastOwner = errors.New("cannot remove the last owner of a conversation")
+
+type Conversation struct {
+	ID        int       `db:"id" json:"id"`
+	Type      string    `db:"type" json:"type"`
+	Name      string    `db:"name" json:"name"`
+	CreatedBy string    `db:"created_by" json:"created_by"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+	MemberIDs []string  `json:"member_ids,omitempty"`
+}
+
+type ConversationMember struct {
+	ConversationID int       `db:"conversation_id" json:"conversation_id"`
+	UserID         string    `db:"user_id" json:"user_id"`
+	Role           string    `db:"role" json:"role"`
+	JoinedAt       time.Time `db:"joined_at" json:"joined_at"`
+}
+
+// directKey builds the unique key for a 1:1 conversation, independent of argument order
+func directKey(userA, userB string) string {
+	if userA > userB {
+		userA, userB = userB, userA
+	}
+	return userA + ":" + userB
+}
+
+// IsValidConversationType reports whether t is a known conversation type
+func IsValidConversationType(t string) bool {
+	switch t {
+	case ConversationDirect, ConversationGroup, ConversationChannel:
+		return true
+	}
+	return false
+}
+
+// CreateConversation stores a group or channel conversation with its creator as owner
+// and the given users as members. Every ID must name a user (ErrUnknownUser).
+func CreateConversation(convType, name, createdBy string, memberIDs []string) (*Conversation, error) {
+	if convType != ConversationGroup && convType != ConversationChannel {
+		return nil, fmt.Errorf("invalid conversation type: %s", convType)
+	}
+	userIDs := []string{createdBy}
+	for _, userID := range memberIDs {
+		if userID != "" {
+			userIDs = append(userIDs, userID)
+		}
+	}
+	if err := ValidateUserIDs(userIDs...); err != nil {
+		return nil, err
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	c := Conversation{Type: convType, Name: name, CreatedBy: createdBy}
+	query := `
+		INSERT INTO conversations (type, name, created_by, created_at)
+		VALUES ($1, $2, $3, NOW())
+		RETURNING id, created_at
+	`
+	if err := tx.QueryRow(query, c.Type, c.Name, c.CreatedBy).Scan(&c.ID, &c.CreatedAt); err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec(`
+		INSERT INTO conversation_members (conversation_id, user_id, role)
+		VALUES ($1, $2, 'owner')
+	`, c.ID, createdBy); err != nil {
+		return nil, err
+	}
+	c.MemberIDs = append(c.MemberIDs, createdBy)
+
+	for _, userID := range memberIDs {
+		if userID == "" || userID == createdBy {
+			continue
+		}
+		res, err := tx.Exec(`
+			INSERT INTO conversation_members (conversation_id, user_id)
+			VALUES ($1, $2)
+			ON CONFLICT DO NOTHING
+		`, c.ID, userID)
+		if err != nil {
+			return nil, err
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			c.MemberIDs = append(c.MemberIDs, userID)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// GetOrCreateDirectConversation returns the 1:1 conversation between two users,
+// creating it on first use. Both IDs must name users (ErrUnknownUser).
+func GetOrCreateDirectConversation(userA, userB string) (*Conversation, error) {
+	if err := ValidateUserIDs(userA, userB); err != nil {
+		return nil, err
+	}
+	key := directKey(userA, userB)
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// The no-op update makes RETURNING yield the existing row on conflict
+	c := Conversation{Type: ConversationDirect}
+	query := `
+		INSERT INTO conversations (type, direct_key, created_by, created_at)
+		VALUES ('direct', $1, $2, NOW())
+		ON CONFLICT (direct_key) DO UPDATE SET direct_key = EXCLUDED.direct_key
+		RETURNING id, name, created_by, created_at
+	`
+	if err := tx.QueryRow(query, key, userA).Scan(&c.ID, &c.Name, &c.CreatedBy, &c.CreatedAt); err != nil {
+		return nil, err
+	}
+
+	for _, userID := range []string{userA, userB} {
+		if _, err := tx.Exec(`
+			INSERT INTO conversation_members (conversation_id, user_id)
+			VALUES ($1, $2)
+			ON CONFLICT DO NOTHING
+		`, c.ID, userID); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	c.MemberIDs = []string{userA}
+	if userB != userA {
+		c.MemberIDs = append(c.MemberIDs, userB)
+	}
+	return &c, nil
+}
+
+// FindDirectConversationID returns the ID of the 1:1 conversation between two
+// users, or 0 if they have never talked
+func FindDirectConversationID(userA, userB string) (int, error) {
+	query := `SELECT id FROM conversations WHERE direct_key = $1`
+
+	var id int
+	err := db.DB.QueryRow(query, directKey(userA, userB)).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return id, err
+}
+
+// GetConversationByID fetches a conversation without its members
+func GetConversationByID(conversationID int) (*Conversation, error) {
+	query := `SELECT id, type, name, created_by, created_at FROM conversations WHERE id = $1`
+
+	var c Conversation
+	err := db.DB.QueryRow(query, conversationID).Scan(&c.ID, &c.Type, &c.Name, &c.CreatedBy, &c.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// GetConversationsForUser lists every conversation the user is a member of
+func GetConversationsForUser(userID string) ([]Conversation, error) {
+	query := `
+		SELECT c.id, c.type, c.name, c.created_by, c.created_at
+		FROM conversations c
+		JOIN conversation_members cm ON cm.conversation_id = c.id
+		WHERE cm.user_id = $1
+		ORDER BY c.created_at DESC
+	`
+	rows, err := db.DB.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var conversations []Conversation
+	for rows.Next() {
+		var c Conversation
+		if err := rows.Scan(&c.ID, &c.Type, &c.Name, &c.CreatedBy, &c.CreatedAt); err != nil {
+			return nil, err
+		}
+		conversations = append(conversations, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range conversations {
+		members, err := GetConversationMemberIDs(conversations[i].ID)
+		if err != nil {
+			return nil, err
+		}
+		conversations[i].MemberIDs = members
+	}
+
+	return conversations, nil
+}
+
+// GetConversationMemberIDs returns the user IDs of every member of a conversation
+func GetConversationMemberIDs(conversationID int) ([]string, error) {
+	query := `SELECT user_id FROM conversation_members WHERE conversation_id = $1 ORDER BY joined_at`
+
+	rows, err := db.DB.Query(query, conversationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var memberIDs []string
+	for rows.Next() {
+		var userID string
+		if err := rows.Scan(&userID); err != nil {
+			return nil, err
+		}
+		memberIDs = append(memberIDs, userID)
+	}
+	return memberIDs, rows.Err()
+}
+
+// IsConversationMember reports whether the user belongs to the conversation
+func IsConversationMember(conversationID int, userID string) (bool, error) {
+	query := `SELECT 1 FROM conversation_members WHERE conversation_id = $1 AND user_id = $2`
+
+	var one int
+	err := db.DB.QueryRow(query, conversationID, userID).Scan(&one)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// GetConversationMemberRole returns the user's role in the conversation, or
+// an empty string if they are not a member
+func GetConversationMemberRole(conversationID int, userID string) (string, error) {
+	query := `SELECT role FROM conversation_members WHERE conversation_id = $1 AND user_id = $2`
+
+	var role string
+	err := db.DB.QueryRow(query, conversationID, userID).Scan(&role)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return role, err
+}
+
+// CanManageMembers reports whether a member role may add and remove members
+func CanManageMembers(role string) bool {
+	return role == MemberRoleOwner || role == MemberRoleAdmin
+}
+
+// AddConversationMember adds a user to a group or channel. The ID must name a
+// user (ErrUnknownUser).
+func AddConversationMember(conversationID int, userID string) error {
+	if err := ValidateUserIDs(userID); err != nil {
+		return err
+	}
+	query := `
+		INSERT INTO conversation_members (conversation_id, user_id)
+		VALUES ($1, $2)
+		ON CONFLICT DO NOTHING
+	`
+	_, err := db.DB.Exec(query, conversationID, userID)
+	return err
+}
+
+// RemoveConversationMember removes a user from a conversation. The last owner
+// cannot be removed.
+func RemoveConversationMember(conversationID int, userID string) error {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Locking the owner rows serializes concurrent removals of owners
+	rows, err := tx.Query(`
+		SELECT user_id FROM conversation_members
+		WHERE conversation_id = $1 AND role = 'owner'
+		FOR UPDATE
+	`, conversationID)
+	if err != nil {
+		return err
+	}
+	var ownerIDs []string
+	for rows.Next() {
+		var ownerID string
+		if err := rows.Scan(&ownerID); err != nil {
+			rows.Close()
+			return err
+		}
+		ownerIDs = append(ownerIDs, ownerID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if len(ownerIDs) == 1 && ownerIDs[0] == userID {
+		return ErrLastOwner
+	}
+
+	query := `DELETE FROM conversation_members WHERE conversation_id = $1 AND user_id = $2`
+	if _, err := tx.Exec(query, conversationID, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
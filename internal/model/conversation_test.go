@@ -0,0 +1,44 @@
+package model
+
+import (
+	"messaging-service/internal/db/dbtest"
+	"testing"
+)
+
+func TestRemoveConversationMemberKeepsLastOwner(t *testing.T) {
+	dbtest.Require(t)
+	owner := dbtest.CreateUser(t, "owner")
+	member := dbtest.CreateUser(t, "member")
+
+	c, err := CreateConversation(ConversationGroup, "team", owner, []string{member})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := RemoveConversationMember(c.ID, owner); err != ErrLastOwner {
+		t.Fatalf("removing the only owner: got %v, want ErrLastOwner", err)
+	}
+	if role, _ := GetConversationMemberRole(c.ID, owner); role != MemberRoleOwner {
+		t.Fatalf("owner role = %q after refused removal", role)
+	}
+
+	if err := RemoveConversationMember(c.ID, member); err != nil {
+		t.Fatal(err)
+	}
+	if role, _ := GetConversationMemberRole(c.ID, member); role != "" {
+		t.Fatalf("removed member still has role %q", role)
+	}
+}
+
+func TestCanManageMembers(t *testing.T) {
+	for role, want := range map[string]bool{
+		MemberRoleOwner:  true,
+		MemberRoleAdmin:  true,
+		MemberRoleMember: false,
+		"":               false,
+	} {
+		if got := CanManageMembers(role); got != want {
+			t.Errorf("CanManageMembers(%q) = %v, want %v", role, got, want)
+		}
+	}
+}
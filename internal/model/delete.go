@@ -0,0 +1,123 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"messaging-service/internal/db"
+	"time"
+)
+
+// Delete scopes
+const (
+	DeleteForMe       = "me"
+	DeleteForEveryone = "everyone"
+)
+
+// DeleteForEveryoneWindow is how long after sending a message its sender may
+// still delete it for everyone. Zero disables the limit.
+var DeleteForEveryoneWindow = 48 * time.Hour
+
+var (
+	ErrInvalidDeleteScope = errors.New("scope must be \"me\" or \"everyone\"")
+	ErrDeleteWindowPassed = errors.New("message is too old to delete for everyone")
+)
+
+// notHiddenFor is a WHERE condition excluding messages the user deleted for
+// themselves; argN is the placeholder number holding the user ID
+func notHiddenFor(argN int) string {
+	return fmt.Sprintf(
+		"NOT EXISTS (SELECT 1 FROM message_hidden mh WHERE mh.message_id = m.id AND mh.user_id = $%d)", argN)
+}
+
+// HideMessage deletes a message for one user only. The user must belong to the
+// message's conversation.
+func HideMessage(messageID int, userID string) (*Message, error) {
+	m, err := GetMessageByID(messageID)
+	if err == sql.ErrNoRows {
+		return nil, ErrMessageNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	isMember, err := IsConversationMember(m.ConversationID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !isMember {
+		return nil, ErrNotConversationMember
+	}
+
+	query := `
+		INSERT INTO message_hidden (message_id, user_id, hidden_at)
+		VALUES ($1, $2, NOW())
+		ON CONFLICT DO NOTHING
+	`
+	if _, err := db.DB.Exec(query, messageID, userID); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// DeleteMessageForEveryone turns a message into a tombstone: content, edit
+// history, reactions and attachments are cleared and deleted_at is set, while
+// the row, its receipts and its position in history stay. Only the sender may
+// do this, within DeleteForEveryoneWindow of sending. Deleting a tombstone
+// again is a no-op.
+func DeleteMessageForEveryone(messageID int, userID string) (*Message, error) {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var m Message
+	query := `SELECT ` + messageColumns + ` FROM messages m WHERE m.id = $1 FOR UPDATE`
+	if err := scanMessage(tx.QueryRow(query, messageID), &m); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrMessageNotFound
+		}
+		return nil, err
+	}
+
+	if m.SenderID != userID {
+		return nil, ErrNotMessageSender
+	}
+	if m.DeletedAt != nil {
+		return &m, nil
+	}
+	if DeleteForEveryoneWindow > 0 && time.Since(m.CreatedAt) > DeleteForEveryoneWindow {
+		return nil, ErrDeleteWindowPassed
+	}
+
+	var deletedAt time.Time
+	if err := tx.QueryRow(`
+		UPDATE messages
+		SET content = '', deleted_at = NOW(), deleted_by = $2
+		WHERE id = $1
+		RETURNING deleted_at
+	`, m.ID, userID).Scan(&deletedAt); err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM message_edits WHERE message_id = $1`, m.ID); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(`DELETE FROM message_reactions WHERE message_id = $1`, m.ID); err != nil {
+		return nil, err
+	}
+	blobKeys, err := deleteAttachments(tx, m.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	removeBlobs(blobKeys)
+
+	m.Content = ""
+	m.DeletedAt = &deletedAt
+	return &m, nil
+}
@@ -0,0 +1,87 @@
+package model
+
+import (
+	"messaging-service/internal/db"
+	"messaging-service/internal/db/dbtest"
+	"testing"
+	"time"
+)
+
+func TestDeleteForEveryoneWindow(t *testing.T) {
+	dbtest.Require(t)
+	alice := dbtest.CreateUser(t, "alice")
+	bob := dbtest.CreateUser(t, "bob")
+
+	old := sendDirect(t, alice, bob, "old")
+	if _, err := db.DB.Exec(`UPDATE messages SET created_at = $2 WHERE id = $1`,
+		old.ID, time.Now().Add(-DeleteForEveryoneWindow-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := DeleteMessageForEveryone(old.ID, alice); err != ErrDeleteWindowPassed {
+		t.Fatalf("deleting past the window: got %v, want ErrDeleteWindowPassed", err)
+	}
+
+	m := sendDirect(t, alice, bob, "recent")
+	if _, _, err := AddReaction(m.ID, bob, "👍"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := DeleteMessageForEveryone(m.ID, bob); err != ErrNotMessageSender {
+		t.Fatalf("delete by the receiver: got %v, want ErrNotMessageSender", err)
+	}
+
+	deleted, err := DeleteMessageForEveryone(m.ID, alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted.Content != "" || deleted.DeletedAt == nil {
+		t.Fatalf("tombstone is %q, deleted_at %v", deleted.Content, deleted.DeletedAt)
+	}
+	stored, err := GetMessageByID(m.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Content != "" || stored.DeletedAt == nil {
+		t.Fatalf("stored tombstone is %q, deleted_at %v", stored.Content, stored.DeletedAt)
+	}
+	var reactions int
+	if err := db.DB.QueryRow(`SELECT COUNT(*) FROM message_reactions WHERE message_id = $1`, m.ID).Scan(&reactions); err != nil {
+		t.Fatal(err)
+	}
+	if reactions != 0 {
+		t.Fatalf("tombstone kept %d reactions", reactions)
+	}
+
+	// Deleting again is a no-op
+	again, err := DeleteMessageForEveryone(m.ID, alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !again.DeletedAt.Equal(*deleted.DeletedAt) {
+		t.Fatalf("second delete moved deleted_at from %v to %v", *deleted.DeletedAt, *again.DeletedAt)
+	}
+}
+
+func TestHideMessageOnlyHidesForTheUser(t *testing.T) {
+	dbtest.Require(t)
+	alice := dbtest.CreateUser(t, "alice")
+	bob := dbtest.CreateUser(t, "bob")
+	outsider := dbtest.CreateUser(t, "outsider")
+	m := sendDirect(t, alice, bob, "hello")
+
+	if _, err := HideMessage(m.ID, outsider); err != ErrNotConversationMember {
+		t.Fatalf("hide by a non-member: got %v, want ErrNotConversationMember", err)
+	}
+	if _, err := HideMessage(m.ID, bob); err != nil {
+		t.Fatal(err)
+	}
+
+	for viewer, want := range map[string]int{bob: 0, alice: 1} {
+		page, err := GetConversationPageWithUsers(m.ConversationID, PageQuery{ViewerID: viewer})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page.Messages) != want {
+			t.Errorf("viewer %s sees %d messages, want %d", viewer, len(page.Messages), want)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"messaging-service/internal/db"
+	"time"
+)
+
+var (
+	ErrMessageNotFound  = errors.New("message not found")
+	ErrNotMessageSender = errors.New("only the sender can change this message")
+	ErrEmptyContent     = errors.New("content required")
+	ErrMessageDeleted   = errors.New("message was deleted")
+)
+
+// MessageEdit is a previous version of an edited message
+type MessageEdit struct {
+	ID              int       `db:"id" json:"id"`
+	MessageID       int       `db:"message_id" json:"message_id"`
+	PreviousContent string    `db:"previous_content" json:"previous_content"`
+	EditedBy        string    `db:"edited_by" json:"edited_by"`
+	EditedAt        time.Time `db:"edited_at" json:"edited_at"`
+}
+
+// EditMessage replaces the content of a message sent by editorID, keeping the
+// previous content in message_edits. Editing to identical content is a no-op.
+func EditMessage(messageID int, editorID, content string) (*Message, error) {
+	if content == "" {
+		return nil, ErrEmptyContent
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var m Message
+	query := `SELECT ` + messageColumns + ` FROM messages m WHERE m.id = $1 FOR UPDATE`
+	if err := scanMessage(tx.QueryRow(query, messageID), &m); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrMessageNotFound
+		}
+		return nil, err
+	}
+
+	if m.SenderID != editorID {
+		return nil, ErrNotMessageSender
+	}
+	if m.DeletedAt != nil {
+		return nil, ErrMessageDeleted
+	}
+	if m.Content == content {
+		return &m, nil
+	}
+
+	if _, err := tx.Exec(`
+		INSERT INTO message_edits (message_id, previous_content, edited_by, edited_at)
+		VALUES ($1, $2, $3, NOW())
+	`, m.ID, m.Content, editorID); err != nil {
+		return nil, err
+	}
+
+	var editedAt time.Time
+	if err := tx.QueryRow(`
+		UPDATE messages
+		SET content = $1, edited_at = NOW()
+		WHERE id = $2
+		RETURNING edited_at
+	`, content, m.ID).Scan(&editedAt); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	m.Content = content
+	m.EditedAt = &editedAt
+	return &m, nil
+}
+
+// GetMessageEdits returns the previous versions of a message, oldest first
+func GetMessageEdits(messageID int) ([]MessageEdit, error) {
+	query := `
+		SELECT id, message_id, previous_content, edited_by, edited_at
+		FROM message_edits
+		WHERE message_id = $1
+		ORDER BY edited_at ASC, id ASC
+	`
+	rows, err := db.DB.Query(query, messageID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	edits := []MessageEdit{}
+	for rows.Next() {
+		var e MessageEdit
+		if err := rows.Scan(&e.ID, &e.MessageID, &e.PreviousContent, &e.EditedBy, &e.EditedAt); err != nil {
+			return nil, err
+		}
+		edits = append(edits, e)
+	}
+	return edits, rows.Err()
+}
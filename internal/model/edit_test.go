@@ -0,0 +1,50 @@
+package model
+
+import (
+	"messaging-service/internal/db/dbtest"
+	"testing"
+)
+
+func TestEditMessageKeepsHistory(t *testing.T) {
+	dbtest.Require(t)
+	alice := dbtest.CreateUser(t, "alice")
+	bob := dbtest.CreateUser(t, "bob")
+	m := sendDirect(t, alice, bob, "first")
+
+	if _, err := EditMessage(m.ID, bob, "hijacked"); err != ErrNotMessageSender {
+		t.Fatalf("edit by the receiver: got %v, want ErrNotMessageSender", err)
+	}
+	if _, err := EditMessage(m.ID, alice, ""); err != ErrEmptyContent {
+		t.Fatalf("empty edit: got %v, want ErrEmptyContent", err)
+	}
+	if _, err := EditMessage(0, alice, "x"); err != ErrMessageNotFound {
+		t.Fatalf("missing message: got %v, want ErrMessageNotFound", err)
+	}
+
+	edited, err := EditMessage(m.ID, alice, "second")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if edited.Content != "second" || edited.EditedAt == nil {
+		t.Fatalf("edited message is %q, edited_at %v", edited.Content, edited.EditedAt)
+	}
+
+	// Identical content adds no version
+	if _, err := EditMessage(m.ID, alice, "second"); err != nil {
+		t.Fatal(err)
+	}
+	edits, err := GetMessageEdits(m.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(edits) != 1 || edits[0].PreviousContent != "first" || edits[0].EditedBy != alice {
+		t.Fatalf("edit history is %+v, want the single previous version", edits)
+	}
+
+	if _, err := DeleteMessageForEveryone(m.ID, alice); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := EditMessage(m.ID, alice, "third"); err != ErrMessageDeleted {
+		t.Fatalf("editing a tombstone: got %v, want ErrMessageDeleted", err)
+	}
+}
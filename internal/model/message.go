@@ -1,28 +1,241 @@
 package model
 
 import (
+	"database/sql"
+	"errors"
 	"messaging-service/internal/db"
 	"time"
+
+	"github.com/lib/pq"
+)
+
+var (
+	ErrNoConversationTarget  = errors.New("conversation_id or receiver_id required")
+	ErrNotConversationMember = errors.New("not a member of this conversation")
+	ErrClientMessageIDLength = errors.New("client_message_id must be at most 128 characters")
+	ErrInvalidParent         = errors.New("parent message must be in the same conversation")
+	ErrInvalidReceiver       = errors.New("receiver_id must be a member of the conversation")
 )
 
+// maxClientMessageIDLength bounds client-generated idempotency keys
+const maxClientMessageIDLength = 128
+
 type Message struct {
-	ID         int       `db:"id" json:"id"`
-	SenderID   string    `db:"sender_id" json:"sender_id"`
-	ReceiverID string    `db:"receiver_id" json:"receiver_id"`
-	Content    string    `db:"content" json:"content"`
-	Status     string    `db:"status" json:"status"`
-	CreatedAt  time.Time `db:"created_at" json:"created_at"`
+	ID             int       `db:"id" json:"id"`
+	ConversationID int       `db:"conversation_id" json:"conversation_id"`
+	SenderID       string    `db:"sender_id" json:"sender_id"`
+	ReceiverID     string    `db:"receiver_id" json:"receiver_id,omitempty"`
+	Content        string    `db:"content" json:"content"`
+	Status         string    `db:"status" json:"status"`
+	CreatedAt      time.Time `db:"created_at" json:"created_at"`
+
+	// ClientMessageID is an optional idempotency key chosen by the client;
+	// retried sends with the same key return the originally stored message
+	ClientMessageID string `db:"client_message_id" json:"client_message_id,omitempty"`
+
+	EditedAt *time.Time `db:"edited_at" json:"edited_at,omitempty"`
+
+	// DeletedAt marks a tombstone: the message was deleted for everyone and
+	// its content cleared, but the row is kept for cursors and receipts
+	DeletedAt *time.Time `db:"deleted_at" json:"deleted_at,omitempty"`
+
+	// ParentID is the thread root this message replies to. Root messages
+	// keep count of their replies.
+	ParentID    int        `db:"parent_id" json:"parent_id,omitempty"`
+	ReplyCount  int        `db:"reply_count" json:"reply_count,omitempty"`
+	LastReplyAt *time.Time `db:"last_reply_at" json:"last_reply_at,omitempty"`
+
+	// AttachmentIDs are uploads to send with the message; Attachments is
+	// what the stored message carries
+	AttachmentIDs []int        `json:"attachment_ids,omitempty"`
+	Attachments   []Attachment `json:"attachments,omitempty"`
+}
+
+// SendRequest holds the fields a client may set when sending a message.
+// Everything else on Message is owned by the server.
+type SendRequest struct {
+	ConversationID  int    `json:"conversation_id"`
+	ReceiverID      string `json:"receiver_id"`
+	Content         string `json:"content"`
+	ClientMessageID string `json:"client_message_id"`
+	ParentID        int    `json:"parent_id"`
+	AttachmentIDs   []int  `json:"attachment_ids"`
+}
+
+// NewMessage returns the message the sender asked for, not yet saved
+func (r *SendRequest) NewMessage(senderID string) Message {
+	return Message{
+		ConversationID:  r.ConversationID,
+		SenderID:        senderID,
+		ReceiverID:      r.ReceiverID,
+		Content:         r.Content,
+		Status:          StatusSent,
+		ClientMessageID: r.ClientMessageID,
+		ParentID:        r.ParentID,
+		AttachmentIDs:   r.AttachmentIDs,
+	}
+}
+
+// messageColumns lists the columns read by scanMessage, qualified with the
+// "m" alias used by every message query
+const messageColumns = `m.id, m.conversation_id, m.sender_id, COALESCE(m.receiver_id, ''),
+	m.content, m.status, m.created_at, COALESCE(m.client_message_id, ''), m.edited_at, m.deleted_at,
+	COALESCE(m.parent_id, 0), m.reply_count, m.last_reply_at`
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanMessage reads a row selected with messageColumns
+func scanMessage(row scanner, m *Message) error {
+	return row.Scan(&m.ID, &m.ConversationID, &m.SenderID, &m.ReceiverID,
+		&m.Content, &m.Status, &m.CreatedAt, &m.ClientMessageID, &m.EditedAt, &m.DeletedAt,
+		&m.ParentID, &m.ReplyCount, &m.LastReplyAt)
+}
+
+// ResolveConversation makes sure the message targets a conversation the sender
+// belongs to. A message with only a ReceiverID is placed in the direct
+// conversation between sender and receiver; a reply inherits the conversation
+// of its thread.
+func (m *Message) ResolveConversation() error {
+	if m.ParentID != 0 {
+		if err := m.resolveThread(); err != nil {
+			return err
+		}
+	}
+
+	if m.ConversationID == 0 {
+		if m.ReceiverID == "" {
+			return ErrNoConversationTarget
+		}
+		c, err := GetOrCreateDirectConversation(m.SenderID, m.ReceiverID)
+		if err != nil {
+			return err
+		}
+		m.ConversationID = c.ID
+		return nil
+	}
+
+	ok, err := IsConversationMember(m.ConversationID, m.SenderID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrNotConversationMember
+	}
+
+	// A receiver named next to the conversation is stored with the message,
+	// so it must be a user in that conversation
+	if m.ReceiverID != "" {
+		if !isUserID(m.ReceiverID) {
+			return ErrInvalidReceiver
+		}
+		ok, err := IsConversationMember(m.ConversationID, m.ReceiverID)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ErrInvalidReceiver
+		}
+	}
+	return nil
 }
 
-// Save the message to the database
-func (m *Message) Save() error {
+// resolveThread validates the parent of a reply. Threads are one level deep:
+// replying to a reply attaches to the same root.
+func (m *Message) resolveThread() error {
+	parent, err := GetMessageByID(m.ParentID)
+	if err == sql.ErrNoRows {
+		return ErrMessageNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if parent.DeletedAt != nil {
+		return ErrMessageDeleted
+	}
+	if parent.ParentID != 0 {
+		m.ParentID = parent.ParentID
+	}
+
+	if m.ConversationID == 0 && m.ReceiverID == "" {
+		m.ConversationID = parent.ConversationID
+	}
+	if m.ConversationID != 0 && m.ConversationID != parent.ConversationID {
+		return ErrInvalidParent
+	}
+	if m.ConversationID == 0 {
+		// Reply addressed by receiver_id: it must resolve to the parent's conversation
+		c, err := GetOrCreateDirectConversation(m.SenderID, m.ReceiverID)
+		if err != nil {
+			return err
+		}
+		if c.ID != parent.ConversationID {
+			return ErrInvalidParent
+		}
+	}
+	return nil
+}
+
+// Save the message to the database together with a pending receipt for every
+// recipient, so undelivered messages can be replayed when they reconnect.
+//
+// When the message carries a ClientMessageID that the sender already used,
+// nothing is inserted: m is replaced by the originally stored message (same
+// ID and CreatedAt) and created is false.
+func (m *Message) Save() (created bool, err error) {
+	if len(m.ClientMessageID) > maxClientMessageIDLength {
+		return false, ErrClientMessageIDLength
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
 	query := `
-		INSERT INTO messages (sender_id, receiver_id, content, status, created_at)
-		VALUES ($1, $2, $3, $4, NOW())
+		INSERT INTO messages (conversation_id, sender_id, receiver_id, content, status, client_message_id, parent_id, created_at)
+		VALUES ($1, $2, NULLIF($3, ''), $4, $5, NULLIF($6, ''), NULLIF($7, 0), NOW())
+		ON CONFLICT (sender_id, client_message_id) WHERE client_message_id IS NOT NULL DO NOTHING
 		RETURNING id, created_at
 	`
-	return db.DB.QueryRow(query, m.SenderID, m.ReceiverID, m.Content, m.Status).
+	err = tx.QueryRow(query, m.ConversationID, m.SenderID, m.ReceiverID, m.Content, m.Status, m.ClientMessageID, m.ParentID).
 		Scan(&m.ID, &m.CreatedAt)
+	if err == sql.ErrNoRows {
+		// Replay of an already stored message
+		existing, err := GetMessageByClientID(m.SenderID, m.ClientMessageID)
+		if err != nil {
+			return false, err
+		}
+		stored := []Message{*existing}
+		if err := loadMessageAttachments(stored); err != nil {
+			return false, err
+		}
+		*m = stored[0]
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if err := linkAttachments(tx, m); err != nil {
+		return false, err
+	}
+	if err := createReceipts(tx, m); err != nil {
+		return false, err
+	}
+
+	if m.ParentID != 0 {
+		if _, err := tx.Exec(`
+			UPDATE messages
+			SET reply_count = reply_count + 1, last_reply_at = $2
+			WHERE id = $1
+		`, m.ParentID, m.CreatedAt); err != nil {
+			return false, err
+		}
+	}
+	return true, tx.Commit()
 }
 
 func (m *Message) UpdateStatus() error {
@@ -35,27 +248,79 @@ func (m *Message) UpdateStatus() error {
 	return err
 }
 
+// GetMessageByID fetches a single message
+func GetMessageByID(messageID int) (*Message, error) {
+	query := `SELECT ` + messageColumns + ` FROM messages m WHERE m.id = $1`
+
+	var m Message
+	if err := scanMessage(db.DB.QueryRow(query, messageID), &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// GetMessageByClientID fetches the message a sender stored under an idempotency key
+func GetMessageByClientID(senderID, clientMessageID string) (*Message, error) {
+	query := `SELECT ` + messageColumns + ` FROM messages m WHERE m.sender_id = $1 AND m.client_message_id = $2`
+
+	var m Message
+	if err := scanMessage(db.DB.QueryRow(query, senderID, clientMessageID), &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// GetMessagesByIDs fetches the given messages with their attachments, oldest
+// first. IDs that do not exist are skipped.
+func GetMessagesByIDs(messageIDs []int) ([]Message, error) {
+	query := `SELECT ` + messageColumns + ` FROM messages m WHERE m.id = ANY($1) ORDER BY m.id ASC`
+	rows, err := db.DB.Query(query, pq.Array(messageIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages, err := scanMessages(rows)
+	if err != nil {
+		return nil, err
+	}
+	return messages, loadMessageAttachments(messages)
+}
+
 func GetMessagesBetween(userA, userB string) ([]Message, error) {
+	conversationID, err := FindDirectConversationID(userA, userB)
+	if err != nil || conversationID == 0 {
+		return nil, err
+	}
+	return GetMessagesInConversation(conversationID)
+}
+
+// GetMessagesInConversation returns every message of a conversation, oldest first
+func GetMessagesInConversation(conversationID int) ([]Message, error) {
 	query := `
-			SELECT id, sender_id, receiver_id, content, status, created_at
-			FROM messages
-			WHERE (sender_id=$1 AND receiver_id=$2)
-				 OR (sender_id=$2 AND receiver_id=$1)
-			ORDER BY created_at ASC
+			SELECT ` + messageColumns + `
+			FROM messages m
+			WHERE m.conversation_id = $1
+			ORDER BY m.created_at ASC
 	`
-	rows, err := db.DB.Query(query, userA, userB)
+	rows, err := db.DB.Query(query, conversationID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
+	return scanMessages(rows)
+}
+
+// scanMessages reads every row selected with messageColumns
+func scanMessages(rows *sql.Rows) ([]Message, error) {
 	var messages []Message
 	for rows.Next() {
 		var m Message
-		if err := rows.Scan(&m.ID, &m.SenderID, &m.ReceiverID, &m.Content, &m.Status, &m.CreatedAt); err != nil {
+		if err := scanMessage(rows, &m); err != nil {
 			return nil, err
 		}
 		messages = append(messages, m)
 	}
-	return messages, nil
+	return messages, rows.Err()
 }
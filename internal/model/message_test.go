@@ -0,0 +1,147 @@
+package model
+
+import (
+	"encoding/json"
+	"messaging-service/internal/db/dbtest"
+	"testing"
+)
+
+// sendDirect stores a direct message between two users
+func sendDirect(t *testing.T, senderID, receiverID, content string) *Message {
+	t.Helper()
+	m := &Message{SenderID: senderID, ReceiverID: receiverID, Content: content, Status: "sent"}
+	if err := m.ResolveConversation(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Save(); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestSaveWithClientMessageIDIsIdempotent(t *testing.T) {
+	dbtest.Require(t)
+	alice := dbtest.CreateUser(t, "alice")
+	bob := dbtest.CreateUser(t, "bob")
+
+	first := &Message{SenderID: alice, ReceiverID: bob, Content: "hi", Status: "sent", ClientMessageID: "retry-1"}
+	if err := first.ResolveConversation(); err != nil {
+		t.Fatal(err)
+	}
+	created, err := first.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !created {
+		t.Fatal("first send was not created")
+	}
+
+	retry := &Message{SenderID: alice, ReceiverID: bob, Content: "hi again", Status: "sent", ClientMessageID: "retry-1"}
+	if err := retry.ResolveConversation(); err != nil {
+		t.Fatal(err)
+	}
+	created, err = retry.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created {
+		t.Fatal("retried send created a second message")
+	}
+	if retry.ID != first.ID || !retry.CreatedAt.Equal(first.CreatedAt) || retry.Content != "hi" {
+		t.Fatalf("retry returned %d %v %q, want the stored message %d %v %q",
+			retry.ID, retry.CreatedAt, retry.Content, first.ID, first.CreatedAt, first.Content)
+	}
+
+	queued, err := GetUndeliveredMessages(bob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queued) != 1 || queued[0].ID != first.ID {
+		t.Fatalf("receiver has %d queued messages, want only %d", len(queued), first.ID)
+	}
+
+	// The key is scoped to the sender
+	other := &Message{SenderID: bob, ReceiverID: alice, Content: "hi", Status: "sent", ClientMessageID: "retry-1"}
+	if err := other.ResolveConversation(); err != nil {
+		t.Fatal(err)
+	}
+	if created, err := other.Save(); err != nil || !created {
+		t.Fatalf("another sender's message with the same key: created %v, err %v", created, err)
+	}
+}
+
+func TestSaveRejectsLongClientMessageID(t *testing.T) {
+	m := &Message{ClientMessageID: string(make([]byte, maxClientMessageIDLength+1))}
+	if _, err := m.Save(); err != ErrClientMessageIDLength {
+		t.Fatalf("got %v, want ErrClientMessageIDLength", err)
+	}
+}
+
+func TestSendRequestDropsServerOwnedFields(t *testing.T) {
+	payload := `{"conversation_id": 7, "content": "hi", "attachment_ids": [3],
+		"sender_id": "99", "status": "read", "edited_at": "2024-01-01T00:00:00Z",
+		"deleted_at": "2024-01-01T00:00:00Z", "reply_count": 5, "last_reply_at": "2024-01-01T00:00:00Z",
+		"attachments": [{"id": 1, "url": "https://evil.example/x", "thumbnail_url": "https://evil.example/t"}]}`
+	var req SendRequest
+	if err := json.Unmarshal([]byte(payload), &req); err != nil {
+		t.Fatal(err)
+	}
+
+	m := req.NewMessage("1")
+	if m.SenderID != "1" || m.Status != StatusSent || m.ConversationID != 7 || m.Content != "hi" ||
+		len(m.AttachmentIDs) != 1 {
+		t.Fatalf("client fields not kept: %+v", m)
+	}
+	if m.EditedAt != nil || m.DeletedAt != nil || m.ReplyCount != 0 || m.LastReplyAt != nil || m.Attachments != nil {
+		t.Fatalf("server-owned fields taken from the client: %+v", m)
+	}
+}
+
+func TestValidateUserIDsRejectsNonUsers(t *testing.T) {
+	for _, id := range []string{"", "bot-x", "007", "+7", "1.5"} {
+		if err := ValidateUserIDs(id); err != ErrUnknownUser {
+			t.Errorf("ValidateUserIDs(%q) = %v, want ErrUnknownUser", id, err)
+		}
+	}
+}
+
+func TestSendToUnknownReceiverIsRejected(t *testing.T) {
+	dbtest.Require(t)
+	alice := dbtest.CreateUser(t, "alice")
+
+	for _, receiverID := range []string{"bot-x", "999999"} {
+		m := &Message{SenderID: alice, ReceiverID: receiverID, Content: "hi", Status: StatusSent}
+		if err := m.ResolveConversation(); err != ErrUnknownUser {
+			t.Errorf("receiver %q: got %v, want ErrUnknownUser", receiverID, err)
+		}
+	}
+}
+
+func TestReceiverNextToConversationMustBeMember(t *testing.T) {
+	dbtest.Require(t)
+	alice := dbtest.CreateUser(t, "alice")
+	bob := dbtest.CreateUser(t, "bob")
+	outsider := dbtest.CreateUser(t, "outsider")
+
+	c, err := CreateConversation(ConversationGroup, "team", alice, []string{bob})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, receiverID := range []string{"x", outsider} {
+		m := &Message{ConversationID: c.ID, SenderID: alice, ReceiverID: receiverID, Content: "hi", Status: StatusSent}
+		if err := m.ResolveConversation(); err != ErrInvalidReceiver {
+			t.Errorf("receiver %q: got %v, want ErrInvalidReceiver", receiverID, err)
+		}
+	}
+
+	m := &Message{ConversationID: c.ID, SenderID: alice, ReceiverID: bob, Content: "hi", Status: StatusSent}
+	if err := m.ResolveConversation(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GetConversationPageWithUsers(c.ID, PageQuery{}); err != nil {
+		t.Fatalf("history after a message with a receiver: %v", err)
+	}
+}
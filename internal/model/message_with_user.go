@@ -1,90 +1,160 @@
 package model
 
 import (
+	"database/sql"
+	"fmt"
 	"messaging-service/internal/db"
 	"time"
 )
 
 // MessageWithUser represents a message with sender and receiver user information
 type MessageWithUser struct {
-	ID           int       `json:"id"`
-	SenderID     string    `json:"sender_id"`
-	ReceiverID   string    `json:"receiver_id"`
-	Content      string    `json:"content"`
-	Status       string    `json:"status"`
-	CreatedAt    time.Time `json:"created_at"`
-	SenderName   string    `json:"sender_name"`
-	SenderEmail  string    `json:"sender_email"`
-	ReceiverName string    `json:"receiver_name"`
-	ReceiverEmail string   `json:"receiver_email"`
+	ID             int        `json:"id"`
+	ConversationID int        `json:"conversation_id"`
+	SenderID       string     `json:"sender_id"`
+	ReceiverID     string     `json:"receiver_id,omitempty"`
+	Content        string     `json:"content"`
+	Status         string     `json:"status"`
+	CreatedAt      time.Time  `json:"created_at"`
+	EditedAt       *time.Time `json:"edited_at,omitempty"`
+	DeletedAt      *time.Time `json:"deleted_at,omitempty"`
+	ParentID       int        `json:"parent_id,omitempty"`
+	ReplyCount     int        `json:"reply_count,omitempty"`
+	LastReplyAt    *time.Time `json:"last_reply_at,omitempty"`
+	SenderName     string     `json:"sender_name"`
+	SenderEmail    string     `json:"sender_email"`
+	ReceiverName   string     `json:"receiver_name,omitempty"`
+	ReceiverEmail  string     `json:"receiver_email,omitempty"`
+
+	Reactions   []ReactionSummary `json:"reactions,omitempty"`
+	Attachments []Attachment      `json:"attachments,omitempty"`
 }
 
-// GetMessagesBetweenWithUsers fetches messages between two users with user information
-func GetMessagesBetweenWithUsers(userA, userB string) ([]MessageWithUser, error) {
-	query := `
-		SELECT 
-			m.id, m.sender_id, m.receiver_id, m.content, m.status, m.created_at,
+// messageWithUserColumns selects the columns scanned by scanMessageWithUser
+// from the tables joined by messageWithUserFrom
+const messageWithUserColumns = `
+			m.id, COALESCE(m.conversation_id, 0), m.sender_id, COALESCE(m.receiver_id, ''),
+			m.content, m.status, m.created_at, m.edited_at, m.deleted_at,
+			COALESCE(m.parent_id, 0), m.reply_count, m.last_reply_at,
 			s.name as sender_name, s.email as sender_email,
-			r.name as receiver_name, r.email as receiver_email
+			COALESCE(r.name, '') as receiver_name, COALESCE(r.email, '') as receiver_email`
+
+// messageWithUserFrom joins sender and receiver. The receiver join is optional
+// because group and channel messages have no receiver.
+const messageWithUserFrom = `
 		FROM messages m
 		JOIN users s ON m.sender_id::int = s.id
-		JOIN users r ON m.receiver_id::int = r.id
-		WHERE (m.sender_id = $1 AND m.receiver_id = $2)
-			 OR (m.sender_id = $2 AND m.receiver_id = $1)
-		ORDER BY m.created_at ASC
-	`
-	
-	rows, err := db.DB.Query(query, userA, userB)
+		LEFT JOIN users r ON NULLIF(m.receiver_id, '')::int = r.id`
+
+// GetConversationPageWithUsers fetches one page of a conversation's history with
+// user information. Only thread roots and unthreaded messages are listed;
+// replies are paged with GetThreadPageWithUsers.
+func GetConversationPageWithUsers(conversationID int, q PageQuery) (*MessagePage, error) {
+	return getPageWithUsers("m.conversation_id = $1 AND m.parent_id IS NULL", conversationID, q)
+}
+
+// GetThreadPageWithUsers fetches one page of replies to a thread root
+func GetThreadPageWithUsers(rootID int, q PageQuery) (*MessagePage, error) {
+	return getPageWithUsers("m.parent_id = $1", rootID, q)
+}
+
+// getPageWithUsers pages messages matching filter (which uses $1 = filterArg)
+// in stable (created_at, id) order
+func getPageWithUsers(filter string, filterArg interface{}, q PageQuery) (*MessagePage, error) {
+	if err := q.normalize(); err != nil {
+		return nil, err
+	}
+
+	args := []interface{}{filterArg}
+	where := filter
+	order := "m.created_at DESC, m.id DESC"
+	switch {
+	case q.Before != nil:
+		args = append(args, q.Before.CreatedAt, q.Before.ID)
+		where += fmt.Sprintf(" AND (m.created_at, m.id) < ($%d, $%d)", len(args)-1, len(args))
+	case q.After != nil:
+		args = append(args, q.After.CreatedAt, q.After.ID)
+		where += fmt.Sprintf(" AND (m.created_at, m.id) > ($%d, $%d)", len(args)-1, len(args))
+		order = "m.created_at ASC, m.id ASC"
+	}
+	if q.ViewerID != "" {
+		args = append(args, q.ViewerID)
+		where += fmt.Sprintf(" AND %s", notHiddenFor(len(args)))
+	}
+
+	// Fetch one extra row to know whether another page follows
+	args = append(args, q.Limit+1)
+	query := fmt.Sprintf(`
+		SELECT %s %s
+		WHERE %s
+		ORDER BY %s
+		LIMIT $%d
+	`, messageWithUserColumns, messageWithUserFrom, where, order, len(args))
+
+	rows, err := db.DB.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var messages []MessageWithUser
-	for rows.Next() {
-		var m MessageWithUser
-		if err := rows.Scan(
-			&m.ID, &m.SenderID, &m.ReceiverID, &m.Content, &m.Status, &m.CreatedAt,
-			&m.SenderName, &m.SenderEmail, &m.ReceiverName, &m.ReceiverEmail,
-		); err != nil {
-			return nil, err
-		}
-		messages = append(messages, m)
+	messages, err := scanMessagesWithUsers(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &MessagePage{Messages: messages}
+	if page.Messages == nil {
+		page.Messages = []MessageWithUser{}
+	}
+	if len(messages) > q.Limit {
+		page.Messages = messages[:q.Limit]
+		last := page.Messages[q.Limit-1]
+		page.NextCursor = Cursor{CreatedAt: last.CreatedAt, ID: last.ID}.Encode()
+	}
+
+	if err := attachReactions(page.Messages, q.ViewerID); err != nil {
+		return nil, err
+	}
+	if err := attachAttachments(page.Messages); err != nil {
+		return nil, err
 	}
-	
-	return messages, nil
+	return page, nil
 }
 
 // GetAllMessagesWithUsers fetches all messages with user information (for admin/debugging)
 func GetAllMessagesWithUsers() ([]MessageWithUser, error) {
 	query := `
-		SELECT 
-			m.id, m.sender_id, m.receiver_id, m.content, m.status, m.created_at,
-			s.name as sender_name, s.email as sender_email,
-			r.name as receiver_name, r.email as receiver_email
-		FROM messages m
-		JOIN users s ON m.sender_id::int = s.id
-		JOIN users r ON m.receiver_id::int = r.id
+		SELECT ` + messageWithUserColumns + messageWithUserFrom + `
 		ORDER BY m.created_at DESC
 	`
-	
+
 	rows, err := db.DB.Query(query)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
+	return scanMessagesWithUsers(rows)
+}
+
+// messageWithUserDest returns the scan destinations matching messageWithUserColumns
+func messageWithUserDest(m *MessageWithUser) []interface{} {
+	return []interface{}{
+		&m.ID, &m.ConversationID, &m.SenderID, &m.ReceiverID, &m.Content, &m.Status, &m.CreatedAt, &m.EditedAt, &m.DeletedAt,
+		&m.ParentID, &m.ReplyCount, &m.LastReplyAt,
+		&m.SenderName, &m.SenderEmail, &m.ReceiverName, &m.ReceiverEmail,
+	}
+}
+
+func scanMessagesWithUsers(rows *sql.Rows) ([]MessageWithUser, error) {
 	var messages []MessageWithUser
 	for rows.Next() {
 		var m MessageWithUser
-		if err := rows.Scan(
-			&m.ID, &m.SenderID, &m.ReceiverID, &m.Content, &m.Status, &m.CreatedAt,
-			&m.SenderName, &m.SenderEmail, &m.ReceiverName, &m.ReceiverEmail,
-		); err != nil {
+		if err := rows.Scan(messageWithUserDest(&m)...); err != nil {
 			return nil, err
 		}
 		messages = append(messages, m)
 	}
-	
-	return messages, nil
+
+	return messages, rows.Err()
 }
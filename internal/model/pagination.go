@@ -0,0 +1,85 @@
+package model
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// History page size limits
+const (
+	DefaultPageLimit = 50
+	MaxPageLimit     = 100
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Cursor is a position in a conversation's history. Messages are ordered by
+// (created_at, id), which is stable even when timestamps collide.
+type Cursor struct {
+	CreatedAt time.Time
+	ID        int
+}
+
+// Encode returns the opaque string form handed to clients
+func (c Cursor) Encode() string {
+	raw := c.CreatedAt.UTC().Format(time.RFC3339Nano) + "|" + strconv.Itoa(c.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// DecodeCursor parses a cursor produced by Cursor.Encode
+func DecodeCursor(s string) (*Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	parts := strings.SplitN(string(raw), "|", 2)
+	if len(parts) != 2 {
+		return nil, ErrInvalidCursor
+	}
+	createdAt, err := time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	id, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return &Cursor{CreatedAt: createdAt, ID: id}, nil
+}
+
+// PageQuery selects one page of a conversation's history. With Before (or no
+// cursor) the page goes backwards from the newest message and is returned
+// newest first; with After it goes forwards and is returned oldest first.
+// Messages the viewer deleted for themselves are skipped.
+type PageQuery struct {
+	Before   *Cursor
+	After    *Cursor
+	Limit    int
+	ViewerID string
+}
+
+// MessagePage is one page of history. NextCursor continues in the same
+// direction and is empty when there are no more messages.
+type MessagePage struct {
+	Messages   []MessageWithUser `json:"messages"`
+	NextCursor string            `json:"next_cursor,omitempty"`
+}
+
+// normalize applies the default and maximum page size
+func (q *PageQuery) normalize() error {
+	if q.Before != nil && q.After != nil {
+		return fmt.Errorf("before and after cannot be combined")
+	}
+	if q.Limit <= 0 {
+		q.Limit = DefaultPageLimit
+	}
+	if q.Limit > MaxPageLimit {
+		q.Limit = MaxPageLimit
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package model
+
+import (
+	"encoding/base64"
+	"messaging-service/internal/db"
+	"messaging-service/internal/db/dbtest"
+	"testing"
+	"time"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	c := Cursor{CreatedAt: time.Date(2024, 5, 1, 12, 30, 0, 123456789, time.UTC), ID: 42}
+	got, err := DecodeCursor(c.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.CreatedAt.Equal(c.CreatedAt) || got.ID != c.ID {
+		t.Fatalf("decoded %+v, want %+v", *got, c)
+	}
+}
+
+func TestDecodeCursorRejectsMalformed(t *testing.T) {
+	for _, s := range []string{
+		"",
+		"not base64!",
+		base64.RawURLEncoding.EncodeToString([]byte("no separator")),
+		base64.RawURLEncoding.EncodeToString([]byte("yesterday|1")),
+		base64.RawURLEncoding.EncodeToString([]byte("2024-05-01T12:30:00Z|x")),
+	} {
+		if _, err := DecodeCursor(s); err != ErrInvalidCursor {
+			t.Errorf("DecodeCursor(%q) = %v, want ErrInvalidCursor", s, err)
+		}
+	}
+}
+
+func TestPageQueryNormalize(t *testing.T) {
+	q := PageQuery{}
+	if err := q.normalize(); err != nil || q.Limit != DefaultPageLimit {
+		t.Fatalf("default limit: got %d, %v", q.Limit, err)
+	}
+	q = PageQuery{Limit: MaxPageLimit + 1}
+	if err := q.normalize(); err != nil || q.Limit != MaxPageLimit {
+		t.Fatalf("capped limit: got %d, %v", q.Limit, err)
+	}
+	q = PageQuery{Before: &Cursor{}, After: &Cursor{}}
+	if err := q.normalize(); err == nil {
+		t.Fatal("before and after together were accepted")
+	}
+}
+
+func TestConversationPagingIsStable(t *testing.T) {
+	dbtest.Require(t)
+	alice := dbtest.CreateUser(t, "alice")
+	bob := dbtest.CreateUser(t, "bob")
+
+	var ids []int
+	var conversationID int
+	for _, content := range []string{"one", "two", "three", "four", "five"} {
+		m := sendDirect(t, alice, bob, content)
+		ids = append(ids, m.ID)
+		conversationID = m.ConversationID
+	}
+	// Colliding timestamps must still page in id order without gaps or repeats
+	if _, err := db.DB.Exec(`UPDATE messages SET created_at = $2 WHERE conversation_id = $1`,
+		conversationID, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	var backward []int
+	q := PageQuery{Limit: 2}
+	for {
+		page, err := GetConversationPageWithUsers(conversationID, q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, m := range page.Messages {
+			backward = append(backward, m.ID)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		if q.Before, err = DecodeCursor(page.NextCursor); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(backward) != len(ids) {
+		t.Fatalf("paging backwards got %v, want %v reversed", backward, ids)
+	}
+	for i, id := range backward {
+		if id != ids[len(ids)-1-i] {
+			t.Fatalf("paging backwards got %v, want %v reversed", backward, ids)
+		}
+	}
+
+	// Forwards from the oldest message returns the rest, oldest first
+	page, err := GetConversationPageWithUsers(conversationID, PageQuery{
+		After: &Cursor{},
+		Limit: 10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Messages) != len(ids) || page.NextCursor != "" {
+		t.Fatalf("paging forwards got %d messages, cursor %q", len(page.Messages), page.NextCursor)
+	}
+	for i, m := range page.Messages {
+		if m.ID != ids[i] {
+			t.Fatalf("paging forwards: message %d is %d, want %d", i, m.ID, ids[i])
+		}
+	}
+}
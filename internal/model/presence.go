@@ -0,0 +1,37 @@
+package model
+
+import (
+	"database/sql"
+	"messaging-service/internal/db"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// RecordLastSeen sets last_seen_at of the given users to now
+func RecordLastSeen(userIDs []string) error {
+	if len(userIDs) == 0 {
+		return nil
+	}
+
+	query := `
+		INSERT INTO user_presence (user_id, last_seen_at)
+		SELECT unnest($1::text[]), NOW()
+		ON CONFLICT (user_id) DO UPDATE SET last_seen_at = EXCLUDED.last_seen_at
+	`
+	_, err := db.DB.Exec(query, pq.Array(userIDs))
+	return err
+}
+
+// GetLastSeen returns when the user was last connected, or nil if never
+func GetLastSeen(userID string) (*time.Time, error) {
+	var lastSeen time.Time
+	err := db.DB.QueryRow(`SELECT last_seen_at FROM user_presence WHERE user_id = $1`, userID).Scan(&lastSeen)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &lastSeen, nil
+}
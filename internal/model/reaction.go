@@ -0,0 +1,162 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"messaging-service/internal/db"
+	"time"
+	"unicode/utf8"
+
+	"github.com/lib/pq"
+)
+
+// maxEmojiLength bounds a reaction in characters; enough for ZWJ sequences
+// and short :shortcodes:
+const maxEmojiLength = 32
+
+var ErrInvalidEmoji = errors.New("emoji must be 1 to 32 characters")
+
+// Reaction is one user's emoji reaction to a message
+type Reaction struct {
+	MessageID      int       `db:"message_id" json:"message_id"`
+	ConversationID int       `json:"conversation_id"`
+	UserID         string    `db:"user_id" json:"user_id"`
+	Emoji          string    `db:"emoji" json:"emoji"`
+	CreatedAt      time.Time `db:"created_at" json:"created_at"`
+}
+
+// ReactionSummary aggregates the reactions with one emoji on a message.
+// Reacted tells whether the viewing user is among them.
+type ReactionSummary struct {
+	Emoji   string `json:"emoji"`
+	Count   int    `json:"count"`
+	Reacted bool   `json:"reacted"`
+}
+
+// loadReactableMessage checks the message exists, is not a tombstone and that
+// the user belongs to its conversation
+func loadReactableMessage(messageID int, userID string) (*Message, error) {
+	m, err := GetMessageByID(messageID)
+	if err == sql.ErrNoRows {
+		return nil, ErrMessageNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if m.DeletedAt != nil {
+		return nil, ErrMessageDeleted
+	}
+
+	isMember, err := IsConversationMember(m.ConversationID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !isMember {
+		return nil, ErrNotConversationMember
+	}
+	return m, nil
+}
+
+func validateEmoji(emoji string) error {
+	if n := utf8.RuneCountInString(emoji); n == 0 || n > maxEmojiLength {
+		return ErrInvalidEmoji
+	}
+	return nil
+}
+
+// AddReaction stores a reaction. changed is false if the user had already
+// reacted with that emoji.
+func AddReaction(messageID int, userID, emoji string) (r *Reaction, changed bool, err error) {
+	if err := validateEmoji(emoji); err != nil {
+		return nil, false, err
+	}
+	m, err := loadReactableMessage(messageID, userID)
+	if err != nil {
+		return nil, false, err
+	}
+
+	r = &Reaction{MessageID: m.ID, ConversationID: m.ConversationID, UserID: userID, Emoji: emoji}
+	query := `
+		INSERT INTO message_reactions (message_id, user_id, emoji, created_at)
+		VALUES ($1, $2, $3, NOW())
+		ON CONFLICT DO NOTHING
+		RETURNING created_at
+	`
+	err = db.DB.QueryRow(query, m.ID, userID, emoji).Scan(&r.CreatedAt)
+	if err == sql.ErrNoRows {
+		return r, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return r, true, nil
+}
+
+// RemoveReaction deletes a reaction. changed is false if there was none.
+func RemoveReaction(messageID int, userID, emoji string) (r *Reaction, changed bool, err error) {
+	if err := validateEmoji(emoji); err != nil {
+		return nil, false, err
+	}
+	m, err := loadReactableMessage(messageID, userID)
+	if err != nil {
+		return nil, false, err
+	}
+
+	r = &Reaction{MessageID: m.ID, ConversationID: m.ConversationID, UserID: userID, Emoji: emoji}
+	query := `DELETE FROM message_reactions WHERE message_id = $1 AND user_id = $2 AND emoji = $3`
+	res, err := db.DB.Exec(query, m.ID, userID, emoji)
+	if err != nil {
+		return nil, false, err
+	}
+	n, _ := res.RowsAffected()
+	return r, n > 0, nil
+}
+
+// GetReactionSummaries aggregates reactions per emoji for each of the messages,
+// marking the ones viewerID took part in. Emojis are ordered by first use.
+func GetReactionSummaries(messageIDs []int, viewerID string) (map[int][]ReactionSummary, error) {
+	summaries := make(map[int][]ReactionSummary)
+	if len(messageIDs) == 0 {
+		return summaries, nil
+	}
+
+	query := `
+		SELECT message_id, emoji, COUNT(*), BOOL_OR(user_id = $2)
+		FROM message_reactions
+		WHERE message_id = ANY($1)
+		GROUP BY message_id, emoji
+		ORDER BY message_id, MIN(created_at)
+	`
+	rows, err := db.DB.Query(query, pq.Array(messageIDs), viewerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var messageID int
+		var s ReactionSummary
+		if err := rows.Scan(&messageID, &s.Emoji, &s.Count, &s.Reacted); err != nil {
+			return nil, err
+		}
+		summaries[messageID] = append(summaries[messageID], s)
+	}
+	return summaries, rows.Err()
+}
+
+// attachReactions fills in the reaction summaries of a page of messages
+func attachReactions(messages []MessageWithUser, viewerID string) error {
+	ids := make([]int, len(messages))
+	for i := range messages {
+		ids[i] = messages[i].ID
+	}
+
+	summaries, err := GetReactionSummaries(ids, viewerID)
+	if err != nil {
+		return err
+	}
+	for i := range messages {
+		messages[i].Reactions = summaries[messages[i].ID]
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package model
+
+import (
+	"messaging-service/internal/db/dbtest"
+	"strings"
+	"testing"
+)
+
+func TestValidateEmoji(t *testing.T) {
+	for emoji, valid := range map[string]bool{
+		"":                                    false,
+		"👍":                                   true,
+		"👩‍👩‍👧‍👦":                             true,
+		":thumbsup:":                          true,
+		strings.Repeat("x", maxEmojiLength):   true,
+		strings.Repeat("x", maxEmojiLength+1): false,
+	} {
+		if err := validateEmoji(emoji); (err == nil) != valid {
+			t.Errorf("validateEmoji(%q) = %v, want valid %v", emoji, err, valid)
+		}
+	}
+}
+
+func TestReactionsAreSummarizedPerViewer(t *testing.T) {
+	dbtest.Require(t)
+	alice := dbtest.CreateUser(t, "alice")
+	bob := dbtest.CreateUser(t, "bob")
+	outsider := dbtest.CreateUser(t, "outsider")
+	m := sendDirect(t, alice, bob, "hello")
+
+	if _, _, err := AddReaction(m.ID, outsider, "👍"); err != ErrNotConversationMember {
+		t.Fatalf("reaction by a non-member: got %v, want ErrNotConversationMember", err)
+	}
+
+	for _, r := range []struct{ user, emoji string }{{bob, "👍"}, {alice, "👍"}, {alice, "🎉"}} {
+		if _, changed, err := AddReaction(m.ID, r.user, r.emoji); err != nil || !changed {
+			t.Fatalf("add %s by %s: changed %v, err %v", r.emoji, r.user, changed, err)
+		}
+	}
+	if _, changed, err := AddReaction(m.ID, bob, "👍"); err != nil || changed {
+		t.Fatalf("repeated reaction: changed %v, err %v", changed, err)
+	}
+
+	summaries, err := GetReactionSummaries([]int{m.ID}, bob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ReactionSummary{{Emoji: "👍", Count: 2, Reacted: true}, {Emoji: "🎉", Count: 1, Reacted: false}}
+	if got := summaries[m.ID]; len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Fatalf("summaries for bob are %+v, want %+v", got, want)
+	}
+
+	if _, changed, err := RemoveReaction(m.ID, bob, "👍"); err != nil || !changed {
+		t.Fatalf("remove: changed %v, err %v", changed, err)
+	}
+	if _, changed, err := RemoveReaction(m.ID, bob, "👍"); err != nil || changed {
+		t.Fatalf("removing again: changed %v, err %v", changed, err)
+	}
+	summaries, err = GetReactionSummaries([]int{m.ID}, bob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := summaries[m.ID]; len(got) != 2 || got[0].Count != 1 || got[0].Reacted {
+		t.Fatalf("summaries after removal are %+v", got)
+	}
+
+	if _, err := DeleteMessageForEveryone(m.ID, alice); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := AddReaction(m.ID, bob, "👍"); err != ErrMessageDeleted {
+		t.Fatalf("reacting to a tombstone: got %v, want ErrMessageDeleted", err)
+	}
+}
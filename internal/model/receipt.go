@@ -0,0 +1,219 @@
+package model
+
+import (
+	"database/sql"
+	"messaging-service/internal/db"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// MessageReceipt tracks delivery and read time of a message for one recipient
+type MessageReceipt struct {
+	MessageID   int        `db:"message_id" json:"message_id"`
+	UserID      string     `db:"user_id" json:"user_id"`
+	DeliveredAt *time.Time `db:"delivered_at" json:"delivered_at"`
+	ReadAt      *time.Time `db:"read_at" json:"read_at"`
+}
+
+// ReceiptUpdate is a receipt that just moved to Status for one recipient
+type ReceiptUpdate struct {
+	MessageID      int       `json:"message_id"`
+	ConversationID int       `json:"conversation_id"`
+	SenderID       string    `json:"sender_id"`
+	UserID         string    `json:"user_id"`
+	Status         string    `json:"status"`
+	At             time.Time `json:"at"`
+}
+
+// createReceipts adds a pending receipt for every conversation member except the sender
+func createReceipts(tx *sql.Tx, m *Message) error {
+	query := `
+		INSERT INTO message_receipts (message_id, user_id)
+		SELECT $1, user_id
+		FROM conversation_members
+		WHERE conversation_id = $2 AND user_id <> $3
+		ON CONFLICT DO NOTHING
+	`
+	_, err := tx.Exec(query, m.ID, m.ConversationID, m.SenderID)
+	return err
+}
+
+// GetUndeliveredMessages returns every message still waiting to be delivered to the
+// user, oldest first
+func GetUndeliveredMessages(userID string) ([]Message, error) {
+	return GetUndeliveredMessagesAfter(userID, 0)
+}
+
+// GetUndeliveredMessagesAfter returns the user's undelivered messages with an ID
+// greater than afterID, oldest first
+func GetUndeliveredMessagesAfter(userID string, afterID int) ([]Message, error) {
+	query := `
+		SELECT ` + messageColumns + `
+		FROM message_receipts mr
+		JOIN messages m ON m.id = mr.message_id
+		WHERE mr.user_id = $1 AND mr.delivered_at IS NULL AND m.id > $2
+		ORDER BY m.id ASC
+	`
+	rows, err := db.DB.Query(query, userID, afterID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages, err := scanMessages(rows)
+	if err != nil {
+		return nil, err
+	}
+	return messages, loadMessageAttachments(messages)
+}
+
+// MarkDelivered records delivery of the given messages to the user and moves a
+// message to "delivered" once every recipient has it. Only receipts that were
+// still pending are returned, so callers can notify senders exactly once.
+func MarkDelivered(userID string, messageIDs []int) ([]ReceiptUpdate, error) {
+	if len(messageIDs) == 0 {
+		return nil, nil
+	}
+
+	query := `
+		UPDATE message_receipts mr
+		SET delivered_at = NOW()
+		FROM messages m
+		WHERE mr.message_id = m.id
+			AND mr.user_id = $1
+			AND mr.message_id = ANY($2)
+			AND mr.delivered_at IS NULL
+		RETURNING m.id, m.conversation_id, m.sender_id, mr.delivered_at
+	`
+	return updateReceipts(StatusDelivered, userID, query, userID, pq.Array(messageIDs))
+}
+
+// MarkReadUpTo marks every message in the conversation up to and including
+// upToMessageID as read by the user. Messages not yet delivered are marked
+// delivered at the same time.
+func MarkReadUpTo(userID string, conversationID, upToMessageID int) ([]ReceiptUpdate, error) {
+	query := `
+		UPDATE message_receipts mr
+		SET read_at = NOW(), delivered_at = COALESCE(mr.delivered_at, NOW())
+		FROM messages m
+		WHERE mr.message_id = m.id
+			AND mr.user_id = $1
+			AND m.conversation_id = $2
+			AND m.id <= $3
+			AND mr.read_at IS NULL
+		RETURNING m.id, m.conversation_id, m.sender_id, mr.read_at
+	`
+	return updateReceipts(StatusRead, userID, query, userID, conversationID, upToMessageID)
+}
+
+// updateReceipts runs a receipt update returning (message id, conversation id,
+// sender id, timestamp) rows and advances the status of the affected messages
+func updateReceipts(status, userID, query string, args ...interface{}) ([]ReceiptUpdate, error) {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	var updates []ReceiptUpdate
+	var messageIDs []int
+	for rows.Next() {
+		r := ReceiptUpdate{UserID: userID, Status: status}
+		if err := rows.Scan(&r.MessageID, &r.ConversationID, &r.SenderID, &r.At); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		updates = append(updates, r)
+		messageIDs = append(messageIDs, r.MessageID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := advanceMessageStatus(tx, messageIDs); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return updates, nil
+}
+
+// advanceMessageStatus recomputes the aggregate status of the given messages
+// from their receipts and stores it when the lifecycle allows the transition
+func advanceMessageStatus(tx *sql.Tx, messageIDs []int) error {
+	if len(messageIDs) == 0 {
+		return nil
+	}
+
+	query := `
+		SELECT m.id, m.status,
+			COUNT(mr.user_id) FILTER (WHERE mr.delivered_at IS NULL),
+			COUNT(mr.user_id) FILTER (WHERE mr.read_at IS NULL)
+		FROM messages m
+		JOIN message_receipts mr ON mr.message_id = m.id
+		WHERE m.id = ANY($1)
+		GROUP BY m.id, m.status
+	`
+	rows, err := tx.Query(query, pq.Array(messageIDs))
+	if err != nil {
+		return err
+	}
+
+	next := make(map[int]string)
+	for rows.Next() {
+		var id, undelivered, unread int
+		var current string
+		if err := rows.Scan(&id, &current, &undelivered, &unread); err != nil {
+			rows.Close()
+			return err
+		}
+		if status := aggregateStatus(undelivered, unread); CanTransition(current, status) {
+			next[id] = status
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for id, status := range next {
+		if _, err := tx.Exec(`UPDATE messages SET status = $1 WHERE id = $2`, status, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetMessageReceipts returns the per-recipient delivery and read timestamps of a message
+func GetMessageReceipts(messageID int) ([]MessageReceipt, error) {
+	query := `
+		SELECT message_id, user_id, delivered_at, read_at
+		FROM message_receipts
+		WHERE message_id = $1
+		ORDER BY user_id
+	`
+	rows, err := db.DB.Query(query, messageID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var receipts []MessageReceipt
+	for rows.Next() {
+		var r MessageReceipt
+		if err := rows.Scan(&r.MessageID, &r.UserID, &r.DeliveredAt, &r.ReadAt); err != nil {
+			return nil, err
+		}
+		receipts = append(receipts, r)
+	}
+	return receipts, rows.Err()
+}
@@ -0,0 +1,142 @@
+package model
+
+import (
+	"messaging-service/internal/db/dbtest"
+	"testing"
+)
+
+func TestCanTransition(t *testing.T) {
+	tests := []struct {
+		from, to string
+		want     bool
+	}{
+		{StatusSent, StatusDelivered, true},
+		{StatusSent, StatusRead, true},
+		{StatusDelivered, StatusRead, true},
+		{StatusSent, StatusSent, false},
+		{StatusDelivered, StatusDelivered, false},
+		{StatusRead, StatusRead, false},
+		{StatusDelivered, StatusSent, false},
+		{StatusRead, StatusDelivered, false},
+		{StatusRead, StatusSent, false},
+		{"", StatusDelivered, false},
+		{StatusSent, "archived", false},
+	}
+	for _, tt := range tests {
+		if got := CanTransition(tt.from, tt.to); got != tt.want {
+			t.Errorf("CanTransition(%q, %q) = %v, want %v", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
+
+func TestAggregateStatus(t *testing.T) {
+	tests := []struct {
+		undelivered, unread int
+		want                string
+	}{
+		{2, 2, StatusSent},
+		{1, 2, StatusSent},
+		{0, 2, StatusDelivered},
+		{0, 1, StatusDelivered},
+		{0, 0, StatusRead},
+	}
+	for _, tt := range tests {
+		if got := aggregateStatus(tt.undelivered, tt.unread); got != tt.want {
+			t.Errorf("aggregateStatus(%d, %d) = %q, want %q", tt.undelivered, tt.unread, got, tt.want)
+		}
+	}
+}
+
+func TestReceiptsAdvanceMessageStatus(t *testing.T) {
+	dbtest.Require(t)
+	alice := dbtest.CreateUser(t, "alice")
+	bob := dbtest.CreateUser(t, "bob")
+	carol := dbtest.CreateUser(t, "carol")
+	outsider := dbtest.CreateUser(t, "outsider")
+
+	c, err := CreateConversation(ConversationGroup, "team", alice, []string{bob, carol})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []int
+	for _, content := range []string{"one", "two"} {
+		m := &Message{ConversationID: c.ID, SenderID: alice, Content: content, Status: StatusSent}
+		if err := m.ResolveConversation(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := m.Save(); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, m.ID)
+	}
+	m1, m2 := ids[0], ids[1]
+
+	delivered := func(userID string, ids ...int) func() ([]ReceiptUpdate, error) {
+		return func() ([]ReceiptUpdate, error) { return MarkDelivered(userID, ids) }
+	}
+	read := func(userID string, upTo int) func() ([]ReceiptUpdate, error) {
+		return func() ([]ReceiptUpdate, error) { return MarkReadUpTo(userID, c.ID, upTo) }
+	}
+
+	steps := []struct {
+		name        string
+		mark        func() ([]ReceiptUpdate, error)
+		wantUpdates []int
+		wantStatus  [2]string // of m1 and m2 afterwards
+	}{
+		{"bob receives one", delivered(bob, m1), []int{m1}, [2]string{StatusSent, StatusSent}},
+		{"bob receives one again", delivered(bob, m1), nil, [2]string{StatusSent, StatusSent}},
+		{"the sender has no receipt", delivered(alice, m1, m2), nil, [2]string{StatusSent, StatusSent}},
+		{"a non-member has no receipt", delivered(outsider, m1), nil, [2]string{StatusSent, StatusSent}},
+		{"carol receives both", delivered(carol, m1, m2), []int{m1, m2}, [2]string{StatusDelivered, StatusSent}},
+		{"bob reads both, delivering two", read(bob, m2), []int{m1, m2}, [2]string{StatusDelivered, StatusDelivered}},
+		{"bob reads both again", read(bob, m2), nil, [2]string{StatusDelivered, StatusDelivered}},
+		{"carol reads one", read(carol, m1), []int{m1}, [2]string{StatusRead, StatusDelivered}},
+		{"late delivery leaves read alone", delivered(carol, m1), nil, [2]string{StatusRead, StatusDelivered}},
+		{"carol reads both", read(carol, m2), []int{m2}, [2]string{StatusRead, StatusRead}},
+	}
+	for _, step := range steps {
+		updates, err := step.mark()
+		if err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if len(updates) != len(step.wantUpdates) {
+			t.Fatalf("%s: updates %+v, want messages %v", step.name, updates, step.wantUpdates)
+		}
+		got := map[int]bool{}
+		for _, u := range updates {
+			got[u.MessageID] = true
+			if u.ConversationID != c.ID || u.SenderID != alice || u.At.IsZero() {
+				t.Errorf("%s: update %+v", step.name, u)
+			}
+		}
+		for _, id := range step.wantUpdates {
+			if !got[id] {
+				t.Fatalf("%s: updates %+v, want messages %v", step.name, updates, step.wantUpdates)
+			}
+		}
+
+		for i, id := range []int{m1, m2} {
+			m, err := GetMessageByID(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if m.Status != step.wantStatus[i] {
+				t.Errorf("%s: message %d is %q, want %q", step.name, id, m.Status, step.wantStatus[i])
+			}
+		}
+	}
+
+	receipts, err := GetMessageReceipts(m1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(receipts) != 2 {
+		t.Fatalf("receipts of message %d: %+v, want bob's and carol's", m1, receipts)
+	}
+	for _, r := range receipts {
+		if r.DeliveredAt == nil || r.ReadAt == nil || r.ReadAt.Before(*r.DeliveredAt) {
+			t.Errorf("receipt %+v", r)
+		}
+	}
+}
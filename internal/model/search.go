@@ -0,0 +1,108 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"messaging-service/internal/db"
+	"time"
+)
+
+// searchConfig is the Postgres text search configuration used for the
+// content_tsv column and for parsing queries; both must match
+const searchConfig = "english"
+
+// Search page size limits
+const (
+	DefaultSearchLimit = 20
+	MaxSearchLimit     = 100
+)
+
+var ErrEmptySearchQuery = errors.New("search query required")
+
+// SearchQuery describes a full-text search over the messages a user can see
+type SearchQuery struct {
+	UserID         string // only conversations this user belongs to are searched
+	Text           string // websearch syntax: words, "quoted phrases", or, -excluded
+	SenderID       string
+	ConversationID int
+	From           *time.Time
+	To             *time.Time
+	Limit          int
+	Offset         int
+}
+
+// SearchResult is a matching message with a highlighted snippet and its rank
+type SearchResult struct {
+	MessageWithUser
+	Snippet string  `json:"snippet"`
+	Rank    float64 `json:"rank"`
+}
+
+// SearchMessages runs a ranked full-text search restricted to the conversations
+// the user participates in. Matches in the snippet are wrapped in <mark> tags.
+func SearchMessages(q SearchQuery) ([]SearchResult, error) {
+	if q.Text == "" {
+		return nil, ErrEmptySearchQuery
+	}
+	if q.Limit <= 0 {
+		q.Limit = DefaultSearchLimit
+	}
+	if q.Limit > MaxSearchLimit {
+		q.Limit = MaxSearchLimit
+	}
+	if q.Offset < 0 {
+		q.Offset = 0
+	}
+
+	args := []interface{}{q.Text, q.UserID}
+	where := `m.content_tsv @@ tsq
+		AND m.deleted_at IS NULL
+		AND m.conversation_id IN (SELECT conversation_id FROM conversation_members WHERE user_id = $2)
+		AND ` + notHiddenFor(2)
+
+	if q.SenderID != "" {
+		args = append(args, q.SenderID)
+		where += fmt.Sprintf(" AND m.sender_id = $%d", len(args))
+	}
+	if q.ConversationID != 0 {
+		args = append(args, q.ConversationID)
+		where += fmt.Sprintf(" AND m.conversation_id = $%d", len(args))
+	}
+	if q.From != nil {
+		args = append(args, *q.From)
+		where += fmt.Sprintf(" AND m.created_at >= $%d", len(args))
+	}
+	if q.To != nil {
+		args = append(args, *q.To)
+		where += fmt.Sprintf(" AND m.created_at < $%d", len(args))
+	}
+
+	args = append(args, q.Limit, q.Offset)
+	query := fmt.Sprintf(`
+		SELECT %s,
+			ts_headline('%s', m.content, tsq, 'StartSel=<mark>, StopSel=</mark>, MaxFragments=2, MaxWords=20, MinWords=5'),
+			ts_rank(m.content_tsv, tsq) AS rank
+		%s
+		CROSS JOIN websearch_to_tsquery('%s', $1) AS tsq
+		WHERE %s
+		ORDER BY rank DESC, m.created_at DESC, m.id DESC
+		LIMIT $%d OFFSET $%d
+	`, messageWithUserColumns, searchConfig, messageWithUserFrom, searchConfig, where, len(args)-1, len(args))
+
+	rows, err := db.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []SearchResult{}
+	for rows.Next() {
+		var r SearchResult
+		dest := append(messageWithUserDest(&r.MessageWithUser), &r.Snippet, &r.Rank)
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		results = append(results, r)
+	}
+	return results, rows.Err()
+}
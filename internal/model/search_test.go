@@ -0,0 +1,93 @@
+package model
+
+import (
+	"messaging-service/internal/db/dbtest"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestSearchMessagesRequiresText(t *testing.T) {
+	if _, err := SearchMessages(SearchQuery{UserID: "1"}); err != ErrEmptySearchQuery {
+		t.Fatalf("got %v, want ErrEmptySearchQuery", err)
+	}
+}
+
+func TestSearchMessages(t *testing.T) {
+	dbtest.Require(t)
+	alice := dbtest.CreateUser(t, "alice")
+	bob := dbtest.CreateUser(t, "bob")
+	carol := dbtest.CreateUser(t, "carol")
+
+	deploying := sendDirect(t, alice, bob, "Deploying the release tonight")
+	sendDirect(t, bob, alice, "lunch plans?")
+	notes := sendDirect(t, bob, alice, "release notes are ready")
+	party := sendDirect(t, carol, bob, "release party on friday")
+
+	ids := func(results []SearchResult) []int {
+		var ids []int
+		for _, r := range results {
+			ids = append(ids, r.ID)
+		}
+		sort.Ints(ids)
+		return ids
+	}
+	sorted := func(ids ...int) []int {
+		sort.Ints(ids)
+		return ids
+	}
+
+	tests := []struct {
+		name string
+		q    SearchQuery
+		want []int
+	}{
+		{"only the user's conversations", SearchQuery{UserID: alice, Text: "release"}, sorted(deploying.ID, notes.ID)},
+		{"member of both", SearchQuery{UserID: bob, Text: "release"}, sorted(deploying.ID, notes.ID, party.ID)},
+		{"stemmed match", SearchQuery{UserID: alice, Text: "deploy"}, []int{deploying.ID}},
+		{"excluded word", SearchQuery{UserID: alice, Text: "release -notes"}, []int{deploying.ID}},
+		{"phrase", SearchQuery{UserID: bob, Text: `"release party"`}, []int{party.ID}},
+		{"sender", SearchQuery{UserID: bob, Text: "release", SenderID: bob}, []int{notes.ID}},
+		{"conversation", SearchQuery{UserID: bob, Text: "release", ConversationID: party.ConversationID}, []int{party.ID}},
+		{"other conversation by ID", SearchQuery{UserID: alice, Text: "release", ConversationID: party.ConversationID}, nil},
+		{"no match", SearchQuery{UserID: alice, Text: "holiday"}, nil},
+	}
+	for _, tt := range tests {
+		results, err := SearchMessages(tt.q)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		got := ids(results)
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: found %v, want %v", tt.name, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s: found %v, want %v", tt.name, got, tt.want)
+				break
+			}
+		}
+		for _, r := range results {
+			if !strings.Contains(r.Snippet, "<mark>") {
+				t.Errorf("%s: snippet %q has no highlighted match", tt.name, r.Snippet)
+			}
+		}
+	}
+
+	// Pages follow the ranking without overlap
+	seen := map[int]bool{}
+	for offset := 0; offset < 3; offset++ {
+		page, err := SearchMessages(SearchQuery{UserID: bob, Text: "release", Limit: 1, Offset: offset})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page) != 1 || seen[page[0].ID] {
+			t.Fatalf("page at offset %d: %v, already seen %v", offset, ids(page), seen)
+		}
+		seen[page[0].ID] = true
+	}
+	if page, err := SearchMessages(SearchQuery{UserID: bob, Text: "release", Limit: 1, Offset: 3}); err != nil || len(page) != 0 {
+		t.Fatalf("page past the end: %v, %v", ids(page), err)
+	}
+}
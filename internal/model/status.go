@@ -0,0 +1,47 @@
+package model
+
+// Message status lifecycle: sent -> delivered -> read. A message only moves
+// forward; it becomes delivered (or read) once every recipient's receipt is.
+const (
+	StatusSent      = "sent"
+	StatusDelivered = "delivered"
+	StatusRead      = "read"
+)
+
+var statusRank = map[string]int{
+	StatusSent:      1,
+	StatusDelivered: 2,
+	StatusRead:      3,
+}
+
+// IsValidStatus reports whether s is a known message status
+func IsValidStatus(s string) bool {
+	_, ok := statusRank[s]
+	return ok
+}
+
+// CanTransition reports whether a message may move from one status to another.
+// Transitions only go forward, but may skip a step (sent -> read).
+func CanTransition(from, to string) bool {
+	fromRank, ok := statusRank[from]
+	if !ok {
+		return false
+	}
+	toRank, ok := statusRank[to]
+	if !ok {
+		return false
+	}
+	return toRank > fromRank
+}
+
+// aggregateStatus derives a message status from its pending receipt counts
+func aggregateStatus(undelivered, unread int) string {
+	switch {
+	case unread == 0:
+		return StatusRead
+	case undelivered == 0:
+		return StatusDelivered
+	default:
+		return StatusSent
+	}
+}
@@ -0,0 +1,38 @@
+package model
+
+import (
+	"messaging-service/internal/db"
+)
+
+// ThreadPage is a thread root followed by one page of its replies
+type ThreadPage struct {
+	Root *Message `json:"root"`
+	MessagePage
+}
+
+// GetThreadParticipantIDs returns the users taking part in a thread: the root's
+// sender and everyone who replied, limited to current conversation members
+func GetThreadParticipantIDs(rootID int) ([]string, error) {
+	query := `
+		SELECT DISTINCT m.sender_id
+		FROM messages m
+		JOIN conversation_members cm
+			ON cm.conversation_id = m.conversation_id AND cm.user_id = m.sender_id
+		WHERE m.id = $1 OR m.parent_id = $1
+	`
+	rows, err := db.DB.Query(query, rootID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var userIDs []string
+	for rows.Next() {
+		var userID string
+		if err := rows.Scan(&userID); err != nil {
+			return nil, err
+		}
+		userIDs = append(userIDs, userID)
+	}
+	return userIDs, rows.Err()
+}
@@ -0,0 +1,139 @@
+package model
+
+import (
+	"messaging-service/internal/db/dbtest"
+	"sort"
+	"testing"
+)
+
+// reply stores a reply to parentID in the parent's conversation
+func reply(t *testing.T, senderID string, parentID int, content string) *Message {
+	t.Helper()
+	m := &Message{SenderID: senderID, ParentID: parentID, Content: content, Status: StatusSent}
+	if err := m.ResolveConversation(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Save(); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestReplyToReplyAttachesToRoot(t *testing.T) {
+	dbtest.Require(t)
+	alice := dbtest.CreateUser(t, "alice")
+	bob := dbtest.CreateUser(t, "bob")
+
+	root := sendDirect(t, alice, bob, "root")
+	first := reply(t, bob, root.ID, "first")
+	nested := reply(t, alice, first.ID, "reply to the reply")
+
+	if first.ParentID != root.ID || nested.ParentID != root.ID {
+		t.Fatalf("parents %d and %d, want both on root %d", first.ParentID, nested.ParentID, root.ID)
+	}
+	if nested.ConversationID != root.ConversationID {
+		t.Fatalf("reply in conversation %d, want the root's %d", nested.ConversationID, root.ConversationID)
+	}
+
+	stored, err := GetMessageByID(root.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.ReplyCount != 2 || stored.LastReplyAt == nil || !stored.LastReplyAt.Equal(nested.CreatedAt) {
+		t.Fatalf("root has %d replies, last at %v, want 2 at %v", stored.ReplyCount, stored.LastReplyAt, nested.CreatedAt)
+	}
+	if stored, err := GetMessageByID(first.ID); err != nil || stored.ReplyCount != 0 {
+		t.Fatalf("reply got %d replies of its own, %v", stored.ReplyCount, err)
+	}
+}
+
+func TestReplyMustStayInTheRootsConversation(t *testing.T) {
+	dbtest.Require(t)
+	alice := dbtest.CreateUser(t, "alice")
+	bob := dbtest.CreateUser(t, "bob")
+	carol := dbtest.CreateUser(t, "carol")
+
+	root := sendDirect(t, alice, bob, "root")
+	group, err := CreateConversation(ConversationGroup, "team", alice, []string{carol})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		m    Message
+		want error
+	}{
+		{"other conversation", Message{ConversationID: group.ID, ParentID: root.ID}, ErrInvalidParent},
+		{"other receiver", Message{ReceiverID: carol, ParentID: root.ID}, ErrInvalidParent},
+		{"missing parent", Message{ParentID: root.ID + 1000}, ErrMessageNotFound},
+		{"same receiver", Message{ReceiverID: bob, ParentID: root.ID}, nil},
+	}
+	for _, tt := range tests {
+		m := tt.m
+		m.SenderID, m.Content, m.Status = alice, "reply", StatusSent
+		if err := m.ResolveConversation(); err != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestThreadParticipantIDs(t *testing.T) {
+	dbtest.Require(t)
+	alice := dbtest.CreateUser(t, "alice")
+	bob := dbtest.CreateUser(t, "bob")
+	carol := dbtest.CreateUser(t, "carol")
+	dave := dbtest.CreateUser(t, "dave")
+
+	group, err := CreateConversation(ConversationGroup, "team", alice, []string{bob, carol, dave})
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := &Message{ConversationID: group.ID, SenderID: alice, Content: "root", Status: StatusSent}
+	if err := root.ResolveConversation(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := root.Save(); err != nil {
+		t.Fatal(err)
+	}
+	first := reply(t, bob, root.ID, "first")
+	reply(t, carol, first.ID, "nested")
+	reply(t, bob, root.ID, "again")
+
+	participants := func() []string {
+		t.Helper()
+		ids, err := GetThreadParticipantIDs(root.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(ids)
+		return ids
+	}
+	want := []string{alice, bob, carol}
+	sort.Strings(want)
+	if got := participants(); !equalStrings(got, want) {
+		t.Fatalf("participants %v, want %v without the silent member", got, want)
+	}
+
+	// Members who left no longer hear about the thread
+	if err := RemoveConversationMember(group.ID, bob); err != nil {
+		t.Fatal(err)
+	}
+	want = []string{alice, carol}
+	sort.Strings(want)
+	if got := participants(); !equalStrings(got, want) {
+		t.Fatalf("participants after bob left %v, want %v", got, want)
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -1,6 +1,8 @@
 package model
 
 import (
+	"database/sql"
+	"errors"
 	"messaging-service/internal/db"
 	"strconv"
 	
@@ -14,6 +16,54 @@ type User struct {
 	Role  int    `db:"role" json:"role"`
 }
 
+// Values of users.role
+const (
+	UserRoleMember = 0
+	UserRoleAdmin  = 1
+)
+
+// ErrUnknownUser is returned for a user ID that names no user
+var ErrUnknownUser = errors.New("unknown user")
+
+// isUserID reports whether id is the decimal form of a users.id, which the
+// ::int joins on sender and receiver rely on
+func isUserID(id string) bool {
+	n, err := strconv.Atoi(id)
+	return err == nil && strconv.Itoa(n) == id
+}
+
+// ValidateUserIDs checks that every ID names an existing user
+func ValidateUserIDs(userIDs ...string) error {
+	for _, id := range userIDs {
+		if !isUserID(id) {
+			return ErrUnknownUser
+		}
+	}
+	users, err := GetUsersByIDs(userIDs)
+	if err != nil {
+		return err
+	}
+	for _, id := range userIDs {
+		if _, ok := users[id]; !ok {
+			return ErrUnknownUser
+		}
+	}
+	return nil
+}
+
+// GetUserRole returns the users.role of a user, or ErrUnknownUser
+func GetUserRole(userID string) (int, error) {
+	if !isUserID(userID) {
+		return 0, ErrUnknownUser
+	}
+	var role int
+	err := db.DB.QueryRow(`SELECT role FROM users WHERE id = $1`, userID).Scan(&role)
+	if err == sql.ErrNoRows {
+		return 0, ErrUnknownUser
+	}
+	return role, err
+}
+
 // GetUserByID fetches a user by their ID
 func GetUserByID(userID string) (*User, error) {
 	query := `SELECT id, name, email, role FROM users WHERE id = $1`
@@ -0,0 +1,166 @@
+package redis
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// deliveryChannelPrefix is the Pub/Sub channel prefix for per-user deliveries
+const deliveryChannelPrefix = "deliver:"
+
+// presenceChannel carries presence changes to every instance
+const presenceChannel = "presence"
+
+// Delivery is a frame published for a user so that every instance holding one
+// of the user's connections can write it to the socket. MessageID is set for
+// chat messages so the receiving instance can record the delivery.
+type Delivery struct {
+	Origin    string `json:"origin"`
+	UserID    string `json:"user_id"`
+	MessageID int    `json:"message_id,omitempty"`
+	Data      []byte `json:"data"`
+}
+
+// DeliveryHandler is called for every delivery published by another instance
+type DeliveryHandler func(d Delivery)
+
+// presenceNotice is a presence change published by one instance
+type presenceNotice struct {
+	Origin   string   `json:"origin"`
+	Presence Presence `json:"presence"`
+}
+
+// PresenceHandler is called for every presence change published by another
+// instance
+type PresenceHandler func(p Presence)
+
+// Bus fans deliveries out across instances using Redis Pub/Sub. Each instance
+// subscribes to the channels of the users it holds connections for and
+// publishes deliveries for every other recipient.
+type Bus struct {
+	client  *redis.Client
+	nodeID  string
+	pubsub  *redis.PubSub
+	handler DeliveryHandler
+	mu      sync.Mutex
+	users   map[string]bool
+
+	presenceHandler PresenceHandler
+}
+
+// NewBus subscribes to Pub/Sub on the given client and starts dispatching
+// deliveries from other instances to handler
+func NewBus(client *redis.Client, nodeID string, handler DeliveryHandler) *Bus {
+	b := &Bus{
+		client:  client,
+		nodeID:  nodeID,
+		pubsub:  client.Subscribe(Ctx),
+		handler: handler,
+		users:   make(map[string]bool),
+	}
+	go b.run()
+	return b
+}
+
+// NodeID identifies this instance on the bus
+func (b *Bus) NodeID() string {
+	return b.nodeID
+}
+
+func (b *Bus) run() {
+	for msg := range b.pubsub.Channel() {
+		if msg.Channel == presenceChannel {
+			b.handlePresence(msg.Payload)
+			continue
+		}
+
+		var d Delivery
+		if err := json.Unmarshal([]byte(msg.Payload), &d); err != nil {
+			log.Println("invalid bus delivery:", err)
+			continue
+		}
+		// Local connections were already served by the publisher
+		if d.Origin == b.nodeID {
+			continue
+		}
+		b.handler(d)
+	}
+}
+
+func (b *Bus) handlePresence(payload string) {
+	var n presenceNotice
+	if err := json.Unmarshal([]byte(payload), &n); err != nil {
+		log.Println("invalid presence notice:", err)
+		return
+	}
+	if n.Origin == b.nodeID {
+		return
+	}
+
+	b.mu.Lock()
+	handler := b.presenceHandler
+	b.mu.Unlock()
+	if handler != nil {
+		handler(n.Presence)
+	}
+}
+
+// OnPresence starts receiving presence changes published by other instances
+func (b *Bus) OnPresence(handler PresenceHandler) error {
+	b.mu.Lock()
+	b.presenceHandler = handler
+	b.mu.Unlock()
+	return b.pubsub.Subscribe(Ctx, presenceChannel)
+}
+
+// PublishPresence announces a user's new presence to every other instance
+func (b *Bus) PublishPresence(p Presence) error {
+	payload, err := json.Marshal(presenceNotice{Origin: b.nodeID, Presence: p})
+	if err != nil {
+		return err
+	}
+	return b.client.Publish(Ctx, presenceChannel, payload).Err()
+}
+
+// Subscribe starts receiving deliveries for a user held by this instance
+func (b *Bus) Subscribe(userID string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.users[userID] {
+		return nil
+	}
+	if err := b.pubsub.Subscribe(Ctx, deliveryChannelPrefix+userID); err != nil {
+		return err
+	}
+	b.users[userID] = true
+	return nil
+}
+
+// Unsubscribe stops receiving deliveries for a user who left this instance
+func (b *Bus) Unsubscribe(userID string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.users[userID] {
+		return nil
+	}
+	delete(b.users, userID)
+	return b.pubsub.Unsubscribe(Ctx, deliveryChannelPrefix+userID)
+}
+
+// Publish sends a delivery for a user to every other instance holding one of
+// their connections. Publishing for a user nobody holds is a no-op in Redis.
+func (b *Bus) Publish(userID string, messageID int, data []byte) error {
+	payload, err := json.Marshal(Delivery{Origin: b.nodeID, UserID: userID, MessageID: messageID, Data: data})
+	if err != nil {
+		return err
+	}
+	return b.client.Publish(Ctx, deliveryChannelPrefix+userID, payload).Err()
+}
+
+// Close unsubscribes from every channel and stops the dispatch loop
+func (b *Bus) Close() error {
+	return b.pubsub.Close()
+}
@@ -0,0 +1,130 @@
+package redis
+
+import (
+	"testing"
+	"time"
+)
+
+// newTestBus starts a bus whose deliveries land on the returned channel
+func newTestBus(t *testing.T, nodeID string) (*Bus, chan Delivery) {
+	t.Helper()
+	deliveries := make(chan Delivery, 10)
+	b := NewBus(Client, nodeID, func(d Delivery) { deliveries <- d })
+	t.Cleanup(func() { b.Close() })
+	return b, deliveries
+}
+
+// waitSubscribed waits until n subscribers listen on the channel
+func waitSubscribed(t *testing.T, channel string, n int64) {
+	t.Helper()
+	waitFor(t, "subscription to "+channel, func() bool {
+		counts, err := Client.PubSubNumSub(Ctx, channel).Result()
+		return err == nil && counts[channel] == n
+	})
+}
+
+func TestBusDeliversAcrossInstances(t *testing.T) {
+	startRedis(t)
+	a, _ := newTestBus(t, "node-a")
+	b, toB := newTestBus(t, "node-b")
+
+	if err := b.Subscribe("42"); err != nil {
+		t.Fatal(err)
+	}
+	waitSubscribed(t, deliveryChannelPrefix+"42", 1)
+
+	if err := a.Publish("42", 7, []byte(`{"type":"message.new"}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case d := <-toB:
+		if d.Origin != "node-a" || d.UserID != "42" || d.MessageID != 7 || string(d.Data) != `{"type":"message.new"}` {
+			t.Fatalf("unexpected delivery %+v", d)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("delivery did not reach the other instance")
+	}
+}
+
+func TestBusSkipsOwnDeliveries(t *testing.T) {
+	startRedis(t)
+	a, _ := newTestBus(t, "node-a")
+	b, toB := newTestBus(t, "node-b")
+
+	if err := b.Subscribe("42"); err != nil {
+		t.Fatal(err)
+	}
+	waitSubscribed(t, deliveryChannelPrefix+"42", 1)
+
+	// Deliveries on one channel arrive in order, so once the second one is
+	// seen the first was already skipped
+	if err := b.Publish("42", 1, []byte("own")); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Publish("42", 2, []byte("other")); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case d := <-toB:
+		if d.Origin != "node-a" {
+			t.Fatalf("bus handed its own delivery back: %+v", d)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("delivery did not arrive")
+	}
+}
+
+func TestBusUnsubscribeStopsDeliveries(t *testing.T) {
+	startRedis(t)
+	b, _ := newTestBus(t, "node-b")
+
+	if err := b.Subscribe("42"); err != nil {
+		t.Fatal(err)
+	}
+	// Subscribing twice keeps a single subscription
+	if err := b.Subscribe("42"); err != nil {
+		t.Fatal(err)
+	}
+	waitSubscribed(t, deliveryChannelPrefix+"42", 1)
+
+	if err := b.Unsubscribe("42"); err != nil {
+		t.Fatal(err)
+	}
+	waitSubscribed(t, deliveryChannelPrefix+"42", 0)
+}
+
+func TestBusPresenceNotices(t *testing.T) {
+	startRedis(t)
+	a, _ := newTestBus(t, "node-a")
+	b, _ := newTestBus(t, "node-b")
+
+	toA := make(chan Presence, 10)
+	toB := make(chan Presence, 10)
+	if err := a.OnPresence(func(p Presence) { toA <- p }); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.OnPresence(func(p Presence) { toB <- p }); err != nil {
+		t.Fatal(err)
+	}
+	waitSubscribed(t, presenceChannel, 2)
+
+	if err := b.PublishPresence(Presence{UserID: "42", State: PresenceAway}); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case p := <-toA:
+		if p.UserID != "42" || p.State != PresenceAway {
+			t.Fatalf("unexpected presence %+v", p)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("presence notice did not reach the other instance")
+	}
+	select {
+	case p := <-toB:
+		t.Fatalf("bus handed its own presence notice back: %+v", p)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
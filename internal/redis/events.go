@@ -0,0 +1,137 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// The event log is a Redis stream whose entry IDs are "<sequence>-0", with
+// sequences taken from a counter so they are plain increasing numbers
+const (
+	eventLogKey = "events:log"
+	eventSeqKey = "events:seq"
+)
+
+// EventLogMaxLen is roughly how many events the log keeps. Subscribers can
+// only resume from sequences still in it.
+var EventLogMaxLen int64 = 100000
+
+var ErrEventsTrimmed = errors.New("events after this sequence are no longer retained")
+
+// Event is a realtime event recorded for stream subscribers. ConversationID
+// and UserIDs name what the event concerns so subscribers can filter on them;
+// Payload is the event's WebSocket payload.
+type Event struct {
+	Sequence       uint64          `json:"-"`
+	Type           string          `json:"type"`
+	ConversationID int             `json:"conversation_id,omitempty"`
+	UserIDs        []string        `json:"user_ids,omitempty"`
+	Payload        json.RawMessage `json:"payload"`
+	At             time.Time       `json:"at"`
+}
+
+// appendEventScript numbers and appends an event atomically so sequences
+// enter the stream in order whichever instance records them
+var appendEventScript = redis.NewScript(`
+local seq = redis.call('INCR', KEYS[2])
+redis.call('XADD', KEYS[1], 'MAXLEN', '~', ARGV[1], seq .. '-0', 'event', ARGV[2])
+return seq
+`)
+
+// AppendEvent records an event and returns its sequence number
+func AppendEvent(e Event) (uint64, error) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return 0, err
+	}
+	seq, err := appendEventScript.Run(Ctx, Client, []string{eventLogKey, eventSeqKey}, EventLogMaxLen, data).Int64()
+	if err != nil {
+		return 0, err
+	}
+	return uint64(seq), nil
+}
+
+// LastEventSequence returns the sequence of the newest recorded event, or 0
+func LastEventSequence() (uint64, error) {
+	seq, err := Client.Get(Ctx, eventSeqKey).Uint64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	return seq, err
+}
+
+// CheckEventsRetained returns ErrEventsTrimmed if events right after the
+// sequence were already trimmed from the log
+func CheckEventsRetained(after uint64) error {
+	oldest, err := Client.XRangeN(Ctx, eventLogKey, "-", "+", 1).Result()
+	if err != nil {
+		return err
+	}
+	if len(oldest) == 0 {
+		last, err := LastEventSequence()
+		if err != nil {
+			return err
+		}
+		if last > after {
+			return ErrEventsTrimmed
+		}
+		return nil
+	}
+
+	first, err := parseEventID(oldest[0].ID)
+	if err != nil {
+		return err
+	}
+	if first > after+1 {
+		return ErrEventsTrimmed
+	}
+	return nil
+}
+
+// ReadEvents returns up to count events with a sequence above after, waiting
+// up to block for one to be recorded. It returns no events on timeout.
+func ReadEvents(ctx context.Context, after uint64, count int64, block time.Duration) ([]Event, error) {
+	streams, err := Client.XRead(ctx, &redis.XReadArgs{
+		Streams: []string{eventLogKey, fmt.Sprintf("%d-0", after)},
+		Count:   count,
+		Block:   block,
+	}).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var events []Event
+	for _, stream := range streams {
+		for _, msg := range stream.Messages {
+			seq, err := parseEventID(msg.ID)
+			if err != nil {
+				return nil, err
+			}
+			raw, _ := msg.Values["event"].(string)
+
+			var e Event
+			if err := json.Unmarshal([]byte(raw), &e); err != nil {
+				return nil, fmt.Errorf("invalid event %s: %w", msg.ID, err)
+			}
+			e.Sequence = seq
+			events = append(events, e)
+		}
+	}
+	return events, nil
+}
+
+// parseEventID extracts the sequence from a "<sequence>-0" stream entry ID
+func parseEventID(id string) (uint64, error) {
+	seq, _, _ := strings.Cut(id, "-")
+	return strconv.ParseUint(seq, 10, 64)
+}
@@ -0,0 +1,126 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// appendEvents records one event per type and fails the test on errors
+func appendEvents(t *testing.T, types ...string) {
+	t.Helper()
+	for _, typ := range types {
+		if _, err := AppendEvent(Event{Type: typ, Payload: json.RawMessage(`{}`)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestAppendAndReadEvents(t *testing.T) {
+	startRedis(t)
+
+	if last, err := LastEventSequence(); err != nil || last != 0 {
+		t.Fatalf("empty log: last sequence %d, %v", last, err)
+	}
+
+	at := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	for i, typ := range []string{"message.new", "message.read", "typing.start"} {
+		seq, err := AppendEvent(Event{
+			Type:           typ,
+			ConversationID: 7,
+			UserIDs:        []string{"1", "2"},
+			Payload:        json.RawMessage(`{"id":1}`),
+			At:             at,
+		})
+		if err != nil || seq != uint64(i+1) {
+			t.Fatalf("append %s: sequence %d, %v", typ, seq, err)
+		}
+	}
+	if last, err := LastEventSequence(); err != nil || last != 3 {
+		t.Fatalf("last sequence %d, %v, want 3", last, err)
+	}
+
+	// Resuming after sequence 1 returns the later events in order
+	events, err := ReadEvents(context.Background(), 1, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[0].Sequence != 2 || events[1].Sequence != 3 {
+		t.Fatalf("events after 1: %+v", events)
+	}
+	e := events[0]
+	if e.Type != "message.read" || e.ConversationID != 7 || len(e.UserIDs) != 2 ||
+		string(e.Payload) != `{"id":1}` || !e.At.Equal(at) {
+		t.Fatalf("event read back as %+v", e)
+	}
+
+	if events, err := ReadEvents(context.Background(), 0, 2, 0); err != nil || len(events) != 2 {
+		t.Fatalf("count 2: %d events, %v", len(events), err)
+	}
+	if events, err := ReadEvents(context.Background(), 3, 10, 10*time.Millisecond); err != nil || len(events) != 0 {
+		t.Fatalf("read past the end: %+v, %v, want no events", events, err)
+	}
+}
+
+func TestReadEventsWaitsForNewEvents(t *testing.T) {
+	startRedis(t)
+	appendEvents(t, "message.new")
+
+	done := make(chan []Event, 1)
+	go func() {
+		events, err := ReadEvents(context.Background(), 1, 10, time.Second)
+		if err != nil {
+			t.Error(err)
+		}
+		done <- events
+	}()
+	time.Sleep(20 * time.Millisecond)
+	appendEvents(t, "message.read")
+
+	select {
+	case events := <-done:
+		if len(events) != 1 || events[0].Sequence != 2 {
+			t.Fatalf("woke with %+v, want event 2", events)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("read did not wake for the new event")
+	}
+}
+
+func TestCheckEventsRetained(t *testing.T) {
+	startRedis(t)
+
+	if err := CheckEventsRetained(0); err != nil {
+		t.Fatalf("empty log: %v", err)
+	}
+
+	appendEvents(t, "a", "b", "c", "d", "e")
+	// Keep events 4 and 5, as MAXLEN trimming eventually would
+	if err := Client.XTrimMaxLen(Ctx, eventLogKey, 2).Err(); err != nil {
+		t.Fatal(err)
+	}
+	for after, want := range map[uint64]error{
+		0: ErrEventsTrimmed,
+		2: ErrEventsTrimmed,
+		3: nil,
+		5: nil,
+	} {
+		if err := CheckEventsRetained(after); err != want {
+			t.Errorf("after %d: %v, want %v", after, err, want)
+		}
+	}
+
+	// With every event trimmed, only a cursor at the newest sequence is current
+	if err := Client.Del(Ctx, eventLogKey).Err(); err != nil && err != redis.Nil {
+		t.Fatal(err)
+	}
+	if err := CheckEventsRetained(4); err != ErrEventsTrimmed {
+		t.Errorf("after 4 with an empty log: %v, want ErrEventsTrimmed", err)
+	}
+	if err := CheckEventsRetained(5); err != nil {
+		t.Errorf("after 5 with an empty log: %v", err)
+	}
+}
@@ -0,0 +1,231 @@
+package redis
+
+import (
+	"encoding/json"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Presence is tracked per connection so a killed instance cannot leave users
+// online forever:
+//
+//	presence:conn:<user>:<conn>  user JSON, expires after PresenceTTL
+//	presence:conns               sorted set of "<user>:<conn>" scored by expiry (ms)
+//	presence:user:<user>         set of the user's connection IDs
+//
+// Each instance refreshes the keys of its live connections with heartbeats.
+// The sorted set lets any instance list who is online and reap entries whose
+// heartbeats stopped.
+const (
+	presenceConnPrefix = "presence:conn:"
+	presenceConnsKey   = "presence:conns"
+	presenceUserPrefix = "presence:user:"
+)
+
+// PresenceTTL is how long a connection stays online without a heartbeat
+var PresenceTTL = 30 * time.Second
+
+// ConnectionPresence identifies one live connection for heartbeats
+type ConnectionPresence struct {
+	UserID string
+	ConnID string
+	User   OnlineUser
+}
+
+func presenceConnKey(userID, connID string) string {
+	return presenceConnPrefix + userID + ":" + connID
+}
+
+func presenceExpiry() string {
+	return strconv.FormatInt(time.Now().Add(PresenceTTL).UnixMilli(), 10)
+}
+
+func nowScore() string {
+	return strconv.FormatInt(time.Now().UnixMilli(), 10)
+}
+
+// MarkConnectionOnline records a new connection of the user
+func MarkConnectionOnline(userID, connID string, user OnlineUser) error {
+	return RefreshPresence([]ConnectionPresence{{UserID: userID, ConnID: connID, User: user}})
+}
+
+// RefreshPresence is the heartbeat: it extends the TTL of every given
+// connection, recreating entries that already expired
+func RefreshPresence(conns []ConnectionPresence) error {
+	if len(conns) == 0 {
+		return nil
+	}
+
+	score, _ := strconv.ParseFloat(presenceExpiry(), 64)
+	_, err := Client.Pipelined(Ctx, func(pipe redis.Pipeliner) error {
+		for _, c := range conns {
+			data, err := json.Marshal(c.User)
+			if err != nil {
+				return err
+			}
+			pipe.Set(Ctx, presenceConnKey(c.UserID, c.ConnID), data, PresenceTTL)
+			pipe.ZAdd(Ctx, presenceConnsKey, &redis.Z{Score: score, Member: c.UserID + ":" + c.ConnID})
+			pipe.SAdd(Ctx, presenceUserPrefix+c.UserID, c.ConnID)
+			pipe.Expire(Ctx, presenceUserPrefix+c.UserID, PresenceTTL)
+		}
+		return nil
+	})
+	return err
+}
+
+// MarkConnectionOffline removes a closed connection. The user stays online
+// while other connections, on any instance, remain.
+func MarkConnectionOffline(userID, connID string) error {
+	_, err := Client.Pipelined(Ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(Ctx, presenceConnKey(userID, connID))
+		pipe.ZRem(Ctx, presenceConnsKey, userID+":"+connID)
+		pipe.SRem(Ctx, presenceUserPrefix+userID, connID)
+		return nil
+	})
+	return err
+}
+
+// liveConnections returns the presence data of the user's live connections on
+// all instances
+func liveConnections(userID string) ([]OnlineUser, error) {
+	connIDs, err := Client.SMembers(Ctx, presenceUserPrefix+userID).Result()
+	if err != nil || len(connIDs) == 0 {
+		return nil, err
+	}
+
+	keys := make([]string, len(connIDs))
+	for i, connID := range connIDs {
+		keys[i] = presenceConnKey(userID, connID)
+	}
+	values, err := Client.MGet(Ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	return decodeConnections(values), nil
+}
+
+// decodeConnections parses MGET results, skipping keys that expired
+func decodeConnections(values []interface{}) []OnlineUser {
+	var conns []OnlineUser
+	for _, v := range values {
+		data, ok := v.(string)
+		if !ok {
+			continue
+		}
+		var user OnlineUser
+		if err := json.Unmarshal([]byte(data), &user); err == nil {
+			conns = append(conns, user)
+		}
+	}
+	return conns
+}
+
+// IsOnline reports whether the user has a live connection on any instance
+func IsOnline(userID string) bool {
+	conns, err := liveConnections(userID)
+	if err != nil {
+		log.Println("Redis IsOnline error:", err)
+		return false
+	}
+	return len(conns) > 0
+}
+
+// GetOnlineUsers lists users with at least one live connection across all
+// instances, with their presence state. Invisible users are left out.
+func GetOnlineUsers() ([]OnlineUser, error) {
+	members, err := Client.ZRangeByScore(Ctx, presenceConnsKey, &redis.ZRangeBy{
+		Min: "(" + nowScore(),
+		Max: "+inf",
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(members) == 0 {
+		return nil, nil
+	}
+
+	keys := make([]string, len(members))
+	for i, member := range members {
+		keys[i] = presenceConnPrefix + member
+	}
+	values, err := Client.MGet(Ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	// A user with several connections is listed once, active if any is
+	users := make(map[string]*OnlineUser)
+	var userIDs []string
+	for _, c := range decodeConnections(values) {
+		if u, ok := users[c.ID]; ok {
+			u.Idle = u.Idle && c.Idle
+			continue
+		}
+		users[c.ID] = &c
+		userIDs = append(userIDs, c.ID)
+	}
+
+	statuses, err := GetStatuses(userIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	var onlineUsers []OnlineUser
+	for _, userID := range userIDs {
+		u, s := users[userID], statuses[userID]
+		u.State = effectiveState(s, true, !u.Idle)
+		if u.State == PresenceOffline {
+			continue
+		}
+		u.StatusText = s.Text
+		onlineUsers = append(onlineUsers, *u)
+	}
+	return onlineUsers, nil
+}
+
+// ReapPresence drops index entries of connections whose heartbeats stopped,
+// e.g. because their instance was killed. It returns the users whose
+// connections were removed.
+func ReapPresence() ([]string, error) {
+	stale, err := Client.ZRangeByScore(Ctx, presenceConnsKey, &redis.ZRangeBy{
+		Min: "-inf",
+		Max: nowScore(),
+	}).Result()
+	if err != nil || len(stale) == 0 {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	var userIDs []string
+	_, err = Client.Pipelined(Ctx, func(pipe redis.Pipeliner) error {
+		for _, member := range stale {
+			// Member is "<user>:<conn>"; connection IDs never contain ':'
+			i := strings.LastIndex(member, ":")
+			if i < 0 {
+				continue
+			}
+			userID := member[:i]
+			pipe.ZRem(Ctx, presenceConnsKey, member)
+			pipe.SRem(Ctx, presenceUserPrefix+userID, member[i+1:])
+			if !seen[userID] {
+				seen[userID] = true
+				userIDs = append(userIDs, userID)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return userIDs, nil
+}
+
+// RemoveLegacyPresence deletes the set and hash used before per-connection
+// presence, whose entries never expired
+func RemoveLegacyPresence() error {
+	return Client.Del(Ctx, "online_users", "online_users_data").Err()
+}
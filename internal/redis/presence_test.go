@@ -0,0 +1,94 @@
+package redis
+
+import (
+	"testing"
+	"time"
+)
+
+// shortPresenceTTL shrinks PresenceTTL for the test
+func shortPresenceTTL(t *testing.T, ttl time.Duration) {
+	t.Helper()
+	prev := PresenceTTL
+	PresenceTTL = ttl
+	t.Cleanup(func() { PresenceTTL = prev })
+}
+
+func TestPresenceExpiresWithoutHeartbeat(t *testing.T) {
+	mr := startRedis(t)
+	shortPresenceTTL(t, time.Second)
+	user := OnlineUser{ID: "1", Name: "alice"}
+
+	if err := MarkConnectionOnline("1", "a", user); err != nil {
+		t.Fatal(err)
+	}
+	if !IsOnline("1") {
+		t.Fatal("user offline right after connecting")
+	}
+
+	// A heartbeat within the TTL keeps the connection
+	mr.FastForward(PresenceTTL / 2)
+	if err := RefreshPresence([]ConnectionPresence{{UserID: "1", ConnID: "a", User: user}}); err != nil {
+		t.Fatal(err)
+	}
+	mr.FastForward(PresenceTTL * 3 / 4)
+	if !IsOnline("1") {
+		t.Fatal("user offline although heartbeats continued")
+	}
+
+	mr.FastForward(PresenceTTL)
+	if IsOnline("1") {
+		t.Fatal("user still online after heartbeats stopped")
+	}
+}
+
+func TestMarkConnectionOfflineKeepsOtherConnections(t *testing.T) {
+	startRedis(t)
+	user := OnlineUser{ID: "1", Name: "alice"}
+	for _, connID := range []string{"a", "b"} {
+		if err := MarkConnectionOnline("1", connID, user); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := MarkConnectionOffline("1", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if !IsOnline("1") {
+		t.Fatal("user offline while another connection lives")
+	}
+	if err := MarkConnectionOffline("1", "b"); err != nil {
+		t.Fatal(err)
+	}
+	if IsOnline("1") {
+		t.Fatal("user online after closing every connection")
+	}
+}
+
+func TestReapPresenceDropsStaleConnections(t *testing.T) {
+	mr := startRedis(t)
+	// The index is scored by wall-clock expiry, so the TTL has to pass for real
+	shortPresenceTTL(t, 20*time.Millisecond)
+
+	if err := MarkConnectionOnline("1", "a", OnlineUser{ID: "1"}); err != nil {
+		t.Fatal(err)
+	}
+	if reaped, err := ReapPresence(); err != nil || len(reaped) != 0 {
+		t.Fatalf("reaped live connection: %v, %v", reaped, err)
+	}
+
+	time.Sleep(2 * PresenceTTL)
+	mr.FastForward(2 * PresenceTTL)
+	reaped, err := ReapPresence()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reaped) != 1 || reaped[0] != "1" {
+		t.Fatalf("reaped %v, want [1]", reaped)
+	}
+	if n, _ := Client.ZCard(Ctx, presenceConnsKey).Result(); n != 0 {
+		t.Fatalf("%d index entries left after reaping", n)
+	}
+	if online, err := GetOnlineUsers(); err != nil || len(online) != 0 {
+		t.Fatalf("online users after reaping: %v, %v", online, err)
+	}
+}
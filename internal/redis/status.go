@@ -0,0 +1,159 @@
+package redis
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Presence states. Users choose online, away, dnd or invisible; others see
+// invisible users as offline. An online user whose connections are all idle
+// is shown as away.
+const (
+	PresenceOnline    = "online"
+	PresenceAway      = "away"
+	PresenceDND       = "dnd"
+	PresenceInvisible = "invisible"
+	PresenceOffline   = "offline"
+)
+
+// presenceStatusKey is a hash of user ID -> chosen Status JSON
+const presenceStatusKey = "presence:status"
+
+const maxStatusTextLength = 140
+
+var (
+	ErrInvalidPresenceState = errors.New("state must be online, away, dnd or invisible")
+	ErrStatusTextLength     = errors.New("status_text must be at most 140 characters")
+)
+
+// Status is what a user chose to show: a state and an optional custom text
+// that disappears after TextExpiresAt
+type Status struct {
+	State         string     `json:"state"`
+	Text          string     `json:"status_text,omitempty"`
+	TextExpiresAt *time.Time `json:"status_expires_at,omitempty"`
+}
+
+// Presence is a user's presence as seen by others
+type Presence struct {
+	UserID        string     `json:"user_id"`
+	State         string     `json:"state"`
+	Text          string     `json:"status_text,omitempty"`
+	TextExpiresAt *time.Time `json:"status_expires_at,omitempty"`
+	LastSeenAt    *time.Time `json:"last_seen_at,omitempty"`
+}
+
+// Validate checks a status before it is stored
+func (s *Status) Validate() error {
+	if s.State == "" {
+		s.State = PresenceOnline
+	}
+	switch s.State {
+	case PresenceOnline, PresenceAway, PresenceDND, PresenceInvisible:
+	default:
+		return ErrInvalidPresenceState
+	}
+	if len([]rune(s.Text)) > maxStatusTextLength {
+		return ErrStatusTextLength
+	}
+	if s.Text == "" {
+		s.TextExpiresAt = nil
+	}
+	return nil
+}
+
+// expire drops a custom text whose expiry passed
+func (s *Status) expire(now time.Time) {
+	if s.TextExpiresAt != nil && !now.Before(*s.TextExpiresAt) {
+		s.Text = ""
+		s.TextExpiresAt = nil
+	}
+}
+
+// SetStatus stores the user's chosen status
+func SetStatus(userID string, s Status) error {
+	if err := s.Validate(); err != nil {
+		return err
+	}
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return Client.HSet(Ctx, presenceStatusKey, userID, data).Err()
+}
+
+// GetStatuses returns the chosen status of each user; users who never set one
+// are online without text
+func GetStatuses(userIDs []string) (map[string]Status, error) {
+	statuses := make(map[string]Status, len(userIDs))
+	if len(userIDs) == 0 {
+		return statuses, nil
+	}
+
+	values, err := Client.HMGet(Ctx, presenceStatusKey, userIDs...).Result()
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	for i, userID := range userIDs {
+		s := Status{State: PresenceOnline}
+		if data, ok := values[i].(string); ok {
+			json.Unmarshal([]byte(data), &s)
+			s.expire(now)
+		}
+		statuses[userID] = s
+	}
+	return statuses, nil
+}
+
+// GetStatus returns the user's chosen status
+func GetStatus(userID string) (Status, error) {
+	statuses, err := GetStatuses([]string{userID})
+	if err != nil {
+		return Status{}, err
+	}
+	return statuses[userID], nil
+}
+
+// effectiveState combines a chosen status with the user's connections: no live
+// connection or invisible means offline, and all-idle connections turn online
+// into away
+func effectiveState(s Status, online, active bool) string {
+	switch {
+	case !online || s.State == PresenceInvisible:
+		return PresenceOffline
+	case s.State == PresenceOnline && !active:
+		return PresenceAway
+	}
+	return s.State
+}
+
+// GetPresence returns the user's presence as others see it. LastSeenAt is left
+// for the caller, which keeps it in Postgres.
+func GetPresence(userID string) (Presence, error) {
+	conns, err := liveConnections(userID)
+	if err != nil {
+		return Presence{}, err
+	}
+	s, err := GetStatus(userID)
+	if err != nil {
+		return Presence{}, err
+	}
+
+	active := false
+	for _, c := range conns {
+		if !c.Idle {
+			active = true
+		}
+	}
+
+	p := Presence{UserID: userID, State: effectiveState(s, len(conns) > 0, active)}
+	if s.State != PresenceInvisible {
+		p.Text, p.TextExpiresAt = s.Text, s.TextExpiresAt
+	}
+	return p, nil
+}
@@ -0,0 +1,111 @@
+package redis
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEffectiveState(t *testing.T) {
+	tests := []struct {
+		state          string
+		online, active bool
+		want           string
+	}{
+		{PresenceOnline, true, true, PresenceOnline},
+		{PresenceOnline, true, false, PresenceAway},
+		{PresenceOnline, false, false, PresenceOffline},
+		{PresenceAway, true, true, PresenceAway},
+		{PresenceAway, true, false, PresenceAway},
+		{PresenceDND, true, true, PresenceDND},
+		{PresenceDND, true, false, PresenceDND},
+		{PresenceDND, false, false, PresenceOffline},
+		{PresenceInvisible, true, true, PresenceOffline},
+		{PresenceInvisible, true, false, PresenceOffline},
+		{PresenceInvisible, false, false, PresenceOffline},
+	}
+	for _, tt := range tests {
+		got := effectiveState(Status{State: tt.state}, tt.online, tt.active)
+		if got != tt.want {
+			t.Errorf("effectiveState(%s, online %v, active %v) = %s, want %s",
+				tt.state, tt.online, tt.active, got, tt.want)
+		}
+	}
+}
+
+func TestStatusValidate(t *testing.T) {
+	expires := time.Now().Add(time.Hour)
+	tests := []struct {
+		name        string
+		status      Status
+		want        error
+		wantState   string
+		wantExpires bool
+	}{
+		{"empty state means online", Status{}, nil, PresenceOnline, false},
+		{"dnd with text", Status{State: PresenceDND, Text: "focus", TextExpiresAt: &expires}, nil, PresenceDND, true},
+		{"expiry without text is dropped", Status{State: PresenceAway, TextExpiresAt: &expires}, nil, PresenceAway, false},
+		{"invisible", Status{State: PresenceInvisible}, nil, PresenceInvisible, false},
+		{"offline cannot be chosen", Status{State: PresenceOffline}, ErrInvalidPresenceState, "", false},
+		{"unknown state", Status{State: "busy"}, ErrInvalidPresenceState, "", false},
+		{"text at the limit", Status{Text: strings.Repeat("é", maxStatusTextLength)}, nil, PresenceOnline, false},
+		{"text too long", Status{Text: strings.Repeat("x", maxStatusTextLength+1)}, ErrStatusTextLength, "", false},
+	}
+	for _, tt := range tests {
+		s := tt.status
+		if err := s.Validate(); err != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+			continue
+		}
+		if tt.want != nil {
+			continue
+		}
+		if s.State != tt.wantState || (s.TextExpiresAt != nil) != tt.wantExpires {
+			t.Errorf("%s: validated as %+v", tt.name, s)
+		}
+	}
+}
+
+func TestGetPresence(t *testing.T) {
+	startRedis(t)
+	expired := time.Now().Add(-time.Minute)
+
+	tests := []struct {
+		name     string
+		status   *Status
+		idle     []bool // one connection per entry
+		want     string
+		wantText string
+	}{
+		{"no connection", nil, nil, PresenceOffline, ""},
+		{"active", nil, []bool{false}, PresenceOnline, ""},
+		{"all idle", nil, []bool{true, true}, PresenceAway, ""},
+		{"one device active", nil, []bool{true, false}, PresenceOnline, ""},
+		{"idle dnd", &Status{State: PresenceDND, Text: "focus"}, []bool{true}, PresenceDND, "focus"},
+		{"invisible hides its text", &Status{State: PresenceInvisible, Text: "secret"}, []bool{false}, PresenceOffline, ""},
+		{"expired text", &Status{State: PresenceOnline, Text: "lunch", TextExpiresAt: &expired}, []bool{false}, PresenceOnline, ""},
+	}
+	for i, tt := range tests {
+		userID := fmt.Sprint(i + 1)
+		if tt.status != nil {
+			if err := SetStatus(userID, *tt.status); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for j, idle := range tt.idle {
+			user := OnlineUser{ID: userID, Idle: idle}
+			if err := MarkConnectionOnline(userID, fmt.Sprint(j), user); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		p, err := GetPresence(userID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.State != tt.want || p.Text != tt.wantText {
+			t.Errorf("%s: presence %s %q, want %s %q", tt.name, p.State, p.Text, tt.want, tt.wantText)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"messaging-service/internal/config"
+)
+
+var ErrNotFound = errors.New("blob not found")
+
+// BlobStore keeps attachment bytes under opaque keys. LocalStore is the
+// default; an S3-compatible store can be plugged in behind the same interface.
+type BlobStore interface {
+	// Put streams r into the store and returns the number of bytes written
+	Put(ctx context.Context, key string, r io.Reader, contentType string) (int64, error)
+	// Get opens a stored blob; it returns ErrNotFound for unknown keys
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// Store is the blob store used by the service
+var Store BlobStore
+
+func Init(cfg *config.Config) error {
+	local, err := NewLocalStore(cfg.BlobDir)
+	if err != nil {
+		return err
+	}
+	Store = local
+
+	if cfg.AttachmentSigningKey != "" {
+		signingKey = []byte(cfg.AttachmentSigningKey)
+	} else {
+		// Signed URLs then only work on this instance until it restarts
+		log.Println("ATTACHMENT_SIGNING_KEY not set, using a random key")
+		signingKey = make([]byte, 32)
+		if _, err := rand.Read(signingKey); err != nil {
+			return err
+		}
+	}
+	urlTTL = cfg.AttachmentURLTTL
+	return nil
+}
+
+// NewKey returns a random key for a new blob
+func NewKey() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,42 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+var (
+	signingKey []byte
+	urlTTL     = 15 * time.Minute
+)
+
+// SignedURL appends an expiry and an HMAC signature to a download path. Whoever
+// holds the URL can fetch the blob until it expires, so it should only be
+// handed to users allowed to see it.
+func SignedURL(path string) string {
+	expires := strconv.FormatInt(time.Now().Add(urlTTL).Unix(), 10)
+	q := url.Values{}
+	q.Set("expires", expires)
+	q.Set("sig", sign(path, expires))
+	return path + "?" + q.Encode()
+}
+
+// VerifySignedURL checks the expires and sig params of a request for path
+func VerifySignedURL(path string, q url.Values) bool {
+	expires := q.Get("expires")
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() > unix {
+		return false
+	}
+	return hmac.Equal([]byte(q.Get("sig")), []byte(sign(path, expires)))
+}
+
+func sign(path, expires string) string {
+	mac := hmac.New(sha256.New, signingKey)
+	mac.Write([]byte(path + "\n" + expires))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLocalStorePutGetDelete(t *testing.T) {
+	ctx := context.Background()
+	s, err := NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := NewKey()
+	n, err := s.Put(ctx, key, strings.NewReader("hello"), "text/plain")
+	if err != nil || n != 5 {
+		t.Fatalf("put: %d bytes, %v", n, err)
+	}
+
+	r, err := s.Get(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(r)
+	r.Close()
+	if err != nil || string(data) != "hello" {
+		t.Fatalf("get: %q, %v", data, err)
+	}
+
+	if err := s.Delete(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get(ctx, key); err != ErrNotFound {
+		t.Fatalf("get after delete: %v, want ErrNotFound", err)
+	}
+	if err := s.Delete(ctx, key); err != nil {
+		t.Fatalf("deleting a missing blob: %v", err)
+	}
+}
+
+func TestLocalStoreRejectsEscapingKeys(t *testing.T) {
+	s, err := NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"", "../x", "a/b", `a\b`, ".."} {
+		if _, err := s.Put(context.Background(), key, strings.NewReader("x"), "text/plain"); err == nil {
+			t.Errorf("put accepted key %q", key)
+		}
+		if _, err := s.Get(context.Background(), key); err == nil || err == ErrNotFound {
+			t.Errorf("get of key %q: %v, want an invalid key error", key, err)
+		}
+	}
+}
+
+func TestVerifySignedURL(t *testing.T) {
+	signingKey = []byte("test key")
+	t.Cleanup(func() { signingKey = nil })
+
+	const path = "/api/attachments/1/content"
+	query := func(rawURL string) url.Values {
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return u.Query()
+	}
+	valid := query(SignedURL(path))
+
+	expired := url.Values{}
+	past := strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10)
+	expired.Set("expires", past)
+	expired.Set("sig", sign(path, past))
+
+	extended := query(SignedURL(path))
+	extended.Set("expires", strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10))
+
+	tampered := query(SignedURL(path))
+	tampered.Set("sig", strings.Repeat("A", len(tampered.Get("sig"))))
+
+	signingKey = []byte("other key")
+	wrongKey := query(SignedURL(path))
+	signingKey = []byte("test key")
+
+	tests := []struct {
+		name string
+		path string
+		q    url.Values
+		want bool
+	}{
+		{"valid", path, valid, true},
+		{"expired", path, expired, false},
+		{"expiry moved", path, extended, false},
+		{"signature changed", path, tampered, false},
+		{"other attachment", "/api/attachments/2/content", valid, false},
+		{"thumbnail of the same attachment", "/api/attachments/1/thumbnail", valid, false},
+		{"signed with another key", path, wrongKey, false},
+		{"missing params", path, url.Values{}, false},
+	}
+	for _, tt := range tests {
+		if got := VerifySignedURL(tt.path, tt.q); got != tt.want {
+			t.Errorf("%s: VerifySignedURL = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -1,101 +1,401 @@
 package websocket
 
 import (
-	"encoding/json"
+	"crypto/rand"
+	"encoding/hex"
+	"expvar"
 	"log"
-	"messaging-service/internal/model"
+	"messaging-service/internal/redis"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
 
+// ConnectionLimits controls keepalive and frame limits of every connection.
+// The server pings every PingInterval and drops connections that send
+// nothing, not even a pong, for PongWait. PingInterval must be shorter than
+// PongWait.
+type ConnectionLimits struct {
+	PingInterval   time.Duration
+	PongWait       time.Duration
+	WriteWait      time.Duration
+	MaxMessageSize int64
+}
+
+// Overflow policies for a full send buffer
+const (
+	// OverflowDisconnect closes the connection with CloseSlowConsumer
+	OverflowDisconnect = "disconnect"
+	// OverflowSpill keeps the connection open; chat messages that did not fit
+	// are resent once the client has drained its buffer, other frames are
+	// dropped
+	OverflowSpill = "spill"
+)
+
+// CloseSlowConsumer is the close code sent to clients disconnected for not
+// reading fast enough
+const CloseSlowConsumer = 4008
+
+// Overflow is the policy applied when a connection's send buffer is full
+var Overflow = OverflowDisconnect
+
+// sendStats counts frames refused by full buffers ("dropped") and clients
+// disconnected for it ("slow_disconnects"). It is not published at
+// /debug/vars, which would expose it on the public port; admins read it
+// through the API.
+var sendStats = new(expvar.Map).Init()
+
+// SendStats returns the overflow counters as a JSON object
+func SendStats() string {
+	return sendStats.String()
+}
+
+// Limits applies to connections created after it is set
+var Limits = ConnectionLimits{
+	PingInterval:   54 * time.Second,
+	PongWait:       60 * time.Second,
+	WriteWait:      10 * time.Second,
+	MaxMessageSize: 64 << 10,
+}
+
 type Connection struct {
+	ID       string
+	UserID   string
 	WS       *websocket.Conn
 	SendChan chan []byte
+	limits   ConnectionLimits
+
+	slowOnce    sync.Once
+	closing     atomic.Bool // set by closeSlow
+	typingLimit *rateLimiter
+	user        redis.OnlineUser // presence data, set on register
+
+	// Client activity drives the idle -> away transition
+	lastActivity atomic.Int64 // unix nanoseconds
+	idle         atomic.Bool
+
+	// While queued messages are replayed, live frames are held back so they
+	// follow the replay
+	holdMu  sync.Mutex
+	holding bool
+	held    []heldFrame
+
+	// With OverflowSpill, the chat messages refused by a full buffer (guarded
+	// by holdMu). While spilling, later frames are refused too so the resend
+	// keeps the order.
+	spilling  bool
+	spilled   []int
+	resending atomic.Bool
+	hub       *Hub // set on register, resends spilled messages
 }
 
+// heldFrame is a live frame held back during a replay. messageID is set for
+// chat messages.
+type heldFrame struct {
+	messageID int
+	data      []byte
+}
+
+// maxHeldFrames bounds the live frames held back during one replay, and the
+// messages spilled by one connection; beyond it the client is disconnected
+const maxHeldFrames = 1024
+
 func NewConnection(ws *websocket.Conn) *Connection {
 	c := &Connection{
+		ID:       newConnectionID(),
 		WS:       ws,
 		SendChan: make(chan []byte, 256),
+		limits:   Limits,
+
+		typingLimit: newRateLimiter(typingRate, typingBurst),
 	}
+	c.lastActivity.Store(time.Now().UnixNano())
 	go c.writePump()
 	return c
 }
 
-func (c *Connection) Send(message []byte) {
+// newConnectionID returns a random identifier for a single device connection
+func newConnectionID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		log.Println("error generating connection id:", err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// Send queues a frame for the write pump and reports whether it was accepted.
+// A full buffer means the client cannot keep up; Overflow decides what happens.
+// Chat messages that were not accepted keep a pending receipt and are replayed
+// when the client reconnects, or resent on the same connection with
+// OverflowSpill.
+func (c *Connection) Send(message []byte) bool {
+	return c.sendFrame(0, message)
+}
+
+// sendFrame is Send for a frame carrying the chat message messageID (0 for
+// other frames), so a replay can skip live copies of messages it sent
+func (c *Connection) sendFrame(messageID int, message []byte) bool {
+	c.holdMu.Lock()
+	defer c.holdMu.Unlock()
+
+	if c.holding {
+		if len(c.held) < maxHeldFrames {
+			c.held = append(c.held, heldFrame{messageID: messageID, data: message})
+			// A held chat message is delivered once it is released into the
+			// buffer; until then its receipt stays pending
+			return messageID == 0
+		}
+		return c.overflowLocked(messageID)
+	}
+	if c.spilling {
+		return c.spillLocked(messageID)
+	}
+	return c.queueLocked(messageID, message)
+}
+
+// queueLocked puts a frame in the send buffer without blocking, applying the
+// Overflow policy when it is full. The caller holds holdMu.
+func (c *Connection) queueLocked(messageID int, message []byte) bool {
 	select {
 	case c.SendChan <- message:
+		return true
 	default:
-		log.Println("Send channel full, dropping message")
 	}
+	return c.overflowLocked(messageID)
 }
 
-func (c *Connection) writePump() {
-	for msg := range c.SendChan {
-		err := c.WS.WriteMessage(websocket.TextMessage, msg)
-		if err != nil {
-			log.Println("Write error:", err)
-			break
+// overflowLocked applies the Overflow policy to a frame that did not fit. The
+// caller holds holdMu.
+func (c *Connection) overflowLocked(messageID int) bool {
+	sendStats.Add("dropped", 1)
+	if Overflow == OverflowSpill {
+		return c.spillLocked(messageID)
+	}
+	c.closeSlow()
+	return false
+}
+
+// spillLocked refuses a frame, remembering chat messages for resendSpilled.
+// The caller holds holdMu.
+func (c *Connection) spillLocked(messageID int) bool {
+	if !c.spilling {
+		c.spilling = true
+		log.Printf("Send channel full for user %s, spilling messages until the client catches up", c.UserID)
+	}
+	if messageID != 0 {
+		if len(c.spilled) >= maxHeldFrames {
+			c.closeSlow()
+			return false
 		}
+		c.spilled = append(c.spilled, messageID)
 	}
-	c.WS.Close()
+	return false
 }
 
-func (c *Connection) ReadPump(hub *Hub, userID string) {
-	defer func() {
-		hub.Unregister(userID)
-		c.WS.Close()
-	}()
+// takeSpilled ends spilling and returns the spilled messages. Live frames are
+// held until releaseSpilled so they follow the resend.
+func (c *Connection) takeSpilled() []int {
+	c.holdMu.Lock()
+	defer c.holdMu.Unlock()
 
-	for {
-		_, msg, err := c.WS.ReadMessage()
-		if err != nil {
-			log.Println("read error:", err)
-			break
+	ids := c.spilled
+	c.spilled = nil
+	c.spilling = false
+	c.holding = true
+	return ids
+}
+
+// releaseSpilled ends a resend of spilled messages: the frames of the
+// messages are queued, then the live frames held meanwhile, minus copies of
+// the resent messages. Nothing blocks; whatever does not fit spills again.
+// It returns the IDs of the chat messages queued, resent or held. The caller
+// holds the hub's read lock, so the send channel stays open.
+func (c *Connection) releaseSpilled(ids []int, frames [][]byte) []int {
+	c.holdMu.Lock()
+	defer c.holdMu.Unlock()
+
+	var sent []int
+	resent := make(map[int]bool, len(ids))
+	for i, id := range ids {
+		resent[id] = true
+		if c.spilling {
+			c.spillLocked(id)
+			continue
 		}
+		if c.queueLocked(id, frames[i]) {
+			sent = append(sent, id)
+		}
+	}
 
-		var m model.Message
-		if err := json.Unmarshal(msg, &m); err != nil {
-			log.Println("invalid message:", err)
+	for _, f := range c.held {
+		if f.messageID != 0 && resent[f.messageID] {
+			continue
+		}
+		if c.spilling {
+			c.spillLocked(f.messageID)
 			continue
 		}
+		if c.queueLocked(f.messageID, f.data) && f.messageID != 0 {
+			sent = append(sent, f.messageID)
+		}
+	}
+	c.held = nil
+	c.holding = false
+	return sent
+}
 
-		// Enforce sender
-		m.SenderID = userID
-		m.Status = "sent"
+// maybeResend starts resending spilled messages once the send buffer is empty
+func (c *Connection) maybeResend() {
+	if c.hub == nil || len(c.SendChan) > 0 {
+		return
+	}
+	c.holdMu.Lock()
+	pending := c.spilling && !c.holding
+	c.holdMu.Unlock()
+	if pending && c.resending.CompareAndSwap(false, true) {
+		go func() {
+			defer c.resending.Store(false)
+			c.hub.resendSpilled(c)
+		}()
+	}
+}
 
-		// Save message to DB
-		if err := m.Save(); err != nil {
-			log.Println("error saving message:", err)
-			continue
+// closeSlow disconnects a client whose send buffer overflowed, telling it to
+// reconnect and resync. Closing the socket ends ReadPump, which unregisters
+// the connection. Senders may hold the hub lock, so the close frame, which
+// can wait up to WriteWait, is written in the background.
+func (c *Connection) closeSlow() {
+	c.slowOnce.Do(func() {
+		c.closing.Store(true)
+		sendStats.Add("slow_disconnects", 1)
+		log.Printf("Send channel full for user %s, disconnecting slow client", c.UserID)
+
+		go func() {
+			msg := websocket.FormatCloseMessage(CloseSlowConsumer, "slow consumer, reconnect to resync")
+			c.WS.WriteControl(websocket.CloseMessage, msg, time.Now().Add(c.limits.WriteWait))
+			c.WS.Close()
+		}()
+	})
+}
+
+// holdLive starts holding back live frames for a replay
+func (c *Connection) holdLive() {
+	c.holdMu.Lock()
+	c.holding = true
+	c.holdMu.Unlock()
+}
+
+// releaseLive ends a replay: the held frames are queued in order, skipping
+// chat messages in replayed, and live frames flow directly again. Frames held
+// while earlier ones are queued join the next batch, keeping the order. A
+// connection being closed for a stalled replay gets none of them. It returns
+// the held chat messages that were queued, to be marked delivered.
+func (c *Connection) releaseLive(replayed map[int]bool) []int {
+	stalled := c.closing.Load()
+	var delivered []int
+	for {
+		c.holdMu.Lock()
+		frames := c.held
+		c.held = nil
+		if len(frames) == 0 {
+			c.holding = false
+			c.holdMu.Unlock()
+			return delivered
 		}
+		c.holdMu.Unlock()
 
-		// Skip sending back to self
-		if m.ReceiverID == userID {
-			continue
+		for _, f := range frames {
+			if stalled || (f.messageID != 0 && replayed[f.messageID]) {
+				continue
+			}
+			if !c.sendWithTimeout(f.data, replayTimeout) {
+				// The client stopped reading; make it reconnect and resync
+				stalled = true
+				c.closeSlow()
+				continue
+			}
+			if f.messageID != 0 {
+				delivered = append(delivered, f.messageID)
+			}
 		}
+	}
+}
 
-		// Marshal JSON to send to recipient
-		data, _ := json.Marshal(m)
+// sendWithTimeout waits up to timeout for room in the send buffer. It is used
+// for replays, which may queue more frames than the buffer holds.
+func (c *Connection) sendWithTimeout(message []byte, timeout time.Duration) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
 
-		// Send to recipient
-		hub.SendMessage(m.ReceiverID, data)
+	select {
+	case c.SendChan <- message:
+		return true
+	case <-timer.C:
+		log.Println("Send channel full, aborting replay")
+		return false
+	}
+}
 
-		// Update message status to delivered
-		m.Status = "delivered"
-		if err := m.UpdateStatus(); err != nil {
-			log.Println("error updating status:", err)
+// writePump writes queued frames and keepalive pings. A write that misses its
+// deadline closes the socket, which ends ReadPump and unregisters the
+// connection.
+func (c *Connection) writePump() {
+	ticker := time.NewTicker(c.limits.PingInterval)
+	defer func() {
+		ticker.Stop()
+		c.WS.Close()
+	}()
+
+	for {
+		select {
+		case msg, ok := <-c.SendChan:
+			c.WS.SetWriteDeadline(time.Now().Add(c.limits.WriteWait))
+			if !ok {
+				// Unregistered: say goodbye
+				c.WS.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+				return
+			}
+			if err := c.WS.WriteMessage(websocket.TextMessage, msg); err != nil {
+				log.Println("Write error:", err)
+				return
+			}
+			c.maybeResend()
+		case <-ticker.C:
+			c.maybeResend()
+			deadline := time.Now().Add(c.limits.WriteWait)
+			if err := c.WS.WriteControl(websocket.PingMessage, nil, deadline); err != nil {
+				log.Println("Ping error:", err)
+				return
+			}
 		}
+	}
+}
+
+// ReadPump reads envelopes from the client and dispatches them until the
+// connection closes. Frames over MaxMessageSize close the connection, as does
+// silence (no frame and no pong) for PongWait.
+func (c *Connection) ReadPump(hub *Hub) {
+	defer func() {
+		hub.Unregister(c)
+		c.WS.Close()
+	}()
+
+	c.WS.SetReadLimit(c.limits.MaxMessageSize)
+	c.WS.SetReadDeadline(time.Now().Add(c.limits.PongWait))
+	c.WS.SetPongHandler(func(string) error {
+		return c.WS.SetReadDeadline(time.Now().Add(c.limits.PongWait))
+	})
 
-		// Notify sender that message was delivered
-		ack := map[string]interface{}{
-			"type":       "delivery",
-			"message_id": m.ID,
-			"to":         m.ReceiverID,
-			"status":     m.Status,
+	for {
+		_, msg, err := c.WS.ReadMessage()
+		if err != nil {
+			log.Println("read error:", err)
+			break
 		}
+		c.WS.SetReadDeadline(time.Now().Add(c.limits.PongWait))
 
-		ackData, _ := json.Marshal(ack)
-		hub.SendMessage(userID, ackData)
+		hub.dispatcher.Dispatch(hub, c, msg)
 	}
 }
@@ -0,0 +1,212 @@
+package websocket
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// drain returns the frames queued on the connection
+func drain(c *Connection) []string {
+	var frames []string
+	for {
+		select {
+		case f := <-c.SendChan:
+			frames = append(frames, string(f))
+		default:
+			return frames
+		}
+	}
+}
+
+func equalFrames(got []string, want ...string) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestReleaseLiveSkipsReplayedMessages(t *testing.T) {
+	c := &Connection{SendChan: make(chan []byte, 8)}
+	c.holdLive()
+
+	// Held chat messages are not delivered yet, so they are not acknowledged
+	if c.sendFrame(1, []byte("live 1")) || !c.Send([]byte("typing")) || c.sendFrame(2, []byte("live 2")) {
+		t.Fatal("held chat messages reported as delivered, or other frames refused")
+	}
+	if frames := drain(c); len(frames) != 0 {
+		t.Fatalf("frames %v went out during the replay", frames)
+	}
+
+	c.SendChan <- []byte("replay 1")
+	if delivered := c.releaseLive(map[int]bool{1: true}); len(delivered) != 1 || delivered[0] != 2 {
+		t.Fatalf("released %v as delivered, want [2]", delivered)
+	}
+	c.Send([]byte("after"))
+
+	if got := drain(c); !equalFrames(got, "replay 1", "typing", "live 2", "after") {
+		t.Fatalf("got %v", got)
+	}
+}
+
+func TestReleaseLiveDropsHeldFramesOfClosingConnection(t *testing.T) {
+	c := &Connection{SendChan: make(chan []byte, 8)}
+	c.holdLive()
+	c.sendFrame(1, []byte("live 1"))
+
+	// A stalled replay closes the connection; newer frames must not overtake
+	// the messages it left unsent
+	c.closing.Store(true)
+	if delivered := c.releaseLive(nil); len(delivered) != 0 {
+		t.Fatalf("released %v as delivered after a stalled replay", delivered)
+	}
+	if got := drain(c); len(got) != 0 {
+		t.Fatalf("got %v after a stalled replay", got)
+	}
+}
+
+func TestSpillRefusesFramesUntilResent(t *testing.T) {
+	prev := Overflow
+	Overflow = OverflowSpill
+	t.Cleanup(func() { Overflow = prev })
+
+	c := &Connection{SendChan: make(chan []byte, 1)}
+	if !c.sendFrame(1, []byte("m1")) {
+		t.Fatal("frame refused by an empty buffer")
+	}
+	if c.sendFrame(2, []byte("m2")) {
+		t.Fatal("frame accepted by a full buffer")
+	}
+
+	// Once spilling, frames are refused even with room, keeping the order
+	drain(c)
+	if c.sendFrame(3, []byte("m3")) || c.Send([]byte("typing")) {
+		t.Fatal("frame accepted while spilling")
+	}
+
+	ids := c.takeSpilled()
+	if len(ids) != 2 || ids[0] != 2 || ids[1] != 3 {
+		t.Fatalf("spilled %v, want [2 3]", ids)
+	}
+
+	// Live frames during the resend follow it; copies of resent messages are dropped
+	c.sendFrame(3, []byte("m3 live"))
+	c.sendFrame(4, []byte("m4"))
+	c.SendChan = make(chan []byte, 8)
+	sent := c.releaseSpilled(ids, [][]byte{[]byte("m2"), []byte("m3")})
+	if len(sent) != 3 || sent[0] != 2 || sent[1] != 3 || sent[2] != 4 {
+		t.Fatalf("delivered %v, want [2 3 4]", sent)
+	}
+	if got := drain(c); !equalFrames(got, "m2", "m3", "m4") {
+		t.Fatalf("got %v", got)
+	}
+	if !c.Send([]byte("after")) {
+		t.Fatal("frame refused after the resend")
+	}
+}
+
+// startPumps serves one WebSocket with ReadPump and writePump under the given
+// limits and dials it. The returned channel closes when ReadPump returns. The
+// connection is not registered with the hub, so no Redis is involved.
+func startPumps(t *testing.T, limits ConnectionLimits) (*websocket.Conn, <-chan struct{}) {
+	t.Helper()
+	prev := Limits
+	Limits = limits
+	t.Cleanup(func() { Limits = prev })
+
+	hub := NewHub()
+	done := make(chan struct{})
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		c := NewConnection(ws)
+		c.UserID = "1"
+		go func() {
+			c.ReadPump(hub)
+			close(done)
+		}()
+	}))
+	t.Cleanup(srv.Close)
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client, done
+}
+
+func TestReadPumpClosesOnOversizeFrame(t *testing.T) {
+	client, done := startPumps(t, ConnectionLimits{
+		PingInterval:   time.Minute,
+		PongWait:       2 * time.Minute,
+		WriteWait:      time.Second,
+		MaxMessageSize: 32,
+	})
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	// A frame within the limit is dispatched
+	if err := client.WriteMessage(websocket.TextMessage, []byte(`{"type":"ping","id":"1"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if _, reply, err := client.ReadMessage(); err != nil || !strings.Contains(string(reply), `"pong"`) {
+		t.Fatalf("ping within the limit: %s, %v", reply, err)
+	}
+
+	if err := client.WriteMessage(websocket.TextMessage, []byte(`{"type":"ping","id":"`+strings.Repeat("x", 32)+`"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := client.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseMessageTooBig) {
+		t.Fatalf("oversize frame: %v, want close 1009", err)
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("ReadPump kept running after an oversize frame")
+	}
+}
+
+func TestReadPumpClosesWithoutPongs(t *testing.T) {
+	limits := ConnectionLimits{
+		PingInterval:   50 * time.Millisecond,
+		PongWait:       200 * time.Millisecond,
+		WriteWait:      time.Second,
+		MaxMessageSize: 1024,
+	}
+
+	// A client that reads answers the pings and stays connected
+	client, done := startPumps(t, limits)
+	go func() {
+		for {
+			if _, _, err := client.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	select {
+	case <-done:
+		t.Fatal("connection answering pings was closed")
+	case <-time.After(4 * limits.PongWait):
+	}
+
+	// One that never reads sends no pongs and is dropped after PongWait
+	_, done = startPumps(t, limits)
+	select {
+	case <-done:
+	case <-time.After(4 * limits.PongWait):
+		t.Fatal("silent connection outlived the pong wait")
+	}
+}
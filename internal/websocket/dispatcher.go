@@ -0,0 +1,91 @@
+package websocket
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+)
+
+// HandlerFunc handles one inbound envelope for a connection. Returning a
+// *ProtocolError sends that error frame back; any other error is reported as
+// an internal error.
+type HandlerFunc func(hub *Hub, c *Connection, env Envelope) error
+
+// Dispatcher routes inbound envelopes to the handler registered for their type
+type Dispatcher struct {
+	handlers map[string]HandlerFunc
+	mu       sync.RWMutex
+}
+
+func NewDispatcher() *Dispatcher {
+	return &Dispatcher{
+		handlers: make(map[string]HandlerFunc),
+	}
+}
+
+// Handle registers the handler for an event type, replacing any previous one
+func (d *Dispatcher) Handle(eventType string, handler HandlerFunc) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.handlers[eventType] = handler
+}
+
+// Dispatch decodes a raw frame and runs its handler, replying with an error
+// frame when the frame is malformed, unknown or rejected
+func (d *Dispatcher) Dispatch(hub *Hub, c *Connection, frame []byte) {
+	env, err := decodeEnvelope(frame)
+	if err != nil {
+		c.SendError(env.ID, err)
+		return
+	}
+
+	// Anything but a keepalive counts as user activity
+	if env.Type != EventPing {
+		hub.touch(c)
+	}
+
+	d.mu.RLock()
+	handler, ok := d.handlers[env.Type]
+	d.mu.RUnlock()
+	if !ok {
+		c.SendError(env.ID, newProtocolError(ErrCodeUnknownType, "unknown event type %q", env.Type))
+		return
+	}
+
+	if err := handler(hub, c, env); err != nil {
+		c.SendError(env.ID, err)
+	}
+}
+
+// decodePayload unmarshals an envelope payload, mapping failures to an
+// invalid_payload protocol error
+func decodePayload(env Envelope, v interface{}) error {
+	if len(env.Payload) == 0 {
+		return newProtocolError(ErrCodeInvalidPayload, "%s requires a payload", env.Type)
+	}
+	if err := json.Unmarshal(env.Payload, v); err != nil {
+		return newProtocolError(ErrCodeInvalidPayload, "invalid %s payload: %v", env.Type, err)
+	}
+	return nil
+}
+
+// SendEnvelope queues a typed frame for this connection
+func (c *Connection) SendEnvelope(eventType, id string, payload interface{}) bool {
+	data, err := EncodeEnvelope(eventType, id, payload)
+	if err != nil {
+		log.Println("error encoding frame:", err)
+		return false
+	}
+	return c.Send(data)
+}
+
+// SendError replies with an error frame. Errors that are not protocol errors
+// are logged and hidden behind a generic internal error.
+func (c *Connection) SendError(id string, err error) {
+	perr, ok := err.(*ProtocolError)
+	if !ok {
+		log.Println("error handling frame:", err)
+		perr = newProtocolError(ErrCodeInternal, "internal server error")
+	}
+	c.SendEnvelope(EventError, id, perr)
+}
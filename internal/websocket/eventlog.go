@@ -0,0 +1,39 @@
+package websocket
+
+import (
+	"encoding/json"
+	"log"
+	"messaging-service/internal/redis"
+	"time"
+)
+
+// EnableEventLog makes the hub record the events it pushes (new messages,
+// edits, deletes, reactions, receipts and presence changes) in the Redis event
+// log, where gRPC subscribers read them
+func (h *Hub) EnableEventLog() {
+	h.eventLog = true
+}
+
+// recordEvent appends an event concerning the conversation and users to the
+// event log, if enabled
+func (h *Hub) recordEvent(eventType string, conversationID int, userIDs []string, payload interface{}) {
+	if !h.eventLog {
+		return
+	}
+
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		log.Println("error encoding event:", err)
+		return
+	}
+	e := redis.Event{
+		Type:           eventType,
+		ConversationID: conversationID,
+		UserIDs:        userIDs,
+		Payload:        raw,
+		At:             time.Now(),
+	}
+	if _, err := redis.AppendEvent(e); err != nil {
+		log.Println("error recording event:", err)
+	}
+}
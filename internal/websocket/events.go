@@ -0,0 +1,309 @@
+package websocket
+
+import (
+	"messaging-service/internal/model"
+	"messaging-service/internal/redis"
+)
+
+// registerDefaultHandlers wires the built-in client events
+func registerDefaultHandlers(d *Dispatcher) {
+	d.Handle(EventMessageSend, handleMessageSend)
+	d.Handle(EventMessageEdit, handleMessageEdit)
+	d.Handle(EventMessageDelete, handleMessageDelete)
+	d.Handle(EventReactionAdd, handleReaction)
+	d.Handle(EventReactionRemove, handleReaction)
+	d.Handle(EventRead, handleRead)
+	d.Handle(EventTypingStart, handleTypingStart)
+	d.Handle(EventTypingStop, handleTypingStop)
+	d.Handle(EventTyping, handleTypingStart)
+	d.Handle(EventPresenceSet, handlePresenceSet)
+	d.Handle(EventPresenceSubscribe, handlePresenceSubscribe)
+	d.Handle(EventPresenceUnsubscribe, handlePresenceUnsubscribe)
+	d.Handle(EventPing, handlePing)
+}
+
+// handleMessageSend saves a chat message, delivers it to the conversation and
+// acknowledges it to the sending connection with message.sent
+func handleMessageSend(hub *Hub, c *Connection, env Envelope) error {
+	// Only client fields are decoded; the sender is the connection's user
+	var req model.SendRequest
+	if err := decodePayload(env, &req); err != nil {
+		return err
+	}
+	m := req.NewMessage(c.UserID)
+
+	// Resolve the target conversation (conversation_id, or receiver_id for 1:1)
+	if err := m.ResolveConversation(); err != nil {
+		switch err {
+		case model.ErrNoConversationTarget, model.ErrInvalidParent, model.ErrMessageDeleted, model.ErrUnknownUser, model.ErrInvalidReceiver:
+			return newProtocolError(ErrCodeInvalidPayload, "%v", err)
+		case model.ErrNotConversationMember:
+			return newProtocolError(ErrCodeForbidden, "%v", err)
+		case model.ErrMessageNotFound:
+			return newProtocolError(ErrCodeNotFound, "parent %v", err)
+		}
+		return err
+	}
+
+	// Save message to DB; a retried client_message_id yields the stored message
+	created, err := m.Save()
+	if err == model.ErrClientMessageIDLength || err == model.ErrInvalidAttachment {
+		return newProtocolError(ErrCodeInvalidPayload, "%v", err)
+	}
+	if err != nil {
+		return err
+	}
+
+	c.SendEnvelope(EventMessageSent, env.ID, m)
+
+	// Retries were already delivered when first stored
+	if !created {
+		return nil
+	}
+
+	// Deliver to the conversation; offline members get it on reconnect
+	return hub.DeliverMessage(&m, c)
+}
+
+// handleMessageEdit changes the content of one of the sender's messages and
+// broadcasts message.edited to the conversation
+func handleMessageEdit(hub *Hub, c *Connection, env Envelope) error {
+	var p struct {
+		MessageID int    `json:"message_id"`
+		Content   string `json:"content"`
+	}
+	if err := decodePayload(env, &p); err != nil {
+		return err
+	}
+	if p.MessageID == 0 {
+		return newProtocolError(ErrCodeInvalidPayload, "message_id required")
+	}
+
+	m, err := model.EditMessage(p.MessageID, c.UserID, p.Content)
+	switch err {
+	case nil:
+	case model.ErrEmptyContent, model.ErrMessageDeleted:
+		return newProtocolError(ErrCodeInvalidPayload, "%v", err)
+	case model.ErrMessageNotFound:
+		return newProtocolError(ErrCodeNotFound, "%v", err)
+	case model.ErrNotMessageSender:
+		return newProtocolError(ErrCodeForbidden, "%v", err)
+	default:
+		return err
+	}
+
+	c.SendEnvelope(EventMessageEdited, env.ID, m)
+	return hub.BroadcastMessageEdited(m, c)
+}
+
+// handleMessageDelete deletes a message for the user ("me") or, for its
+// sender, for everyone, and tells the affected connections to remove it
+func handleMessageDelete(hub *Hub, c *Connection, env Envelope) error {
+	var p struct {
+		MessageID int    `json:"message_id"`
+		Scope     string `json:"scope"`
+	}
+	if err := decodePayload(env, &p); err != nil {
+		return err
+	}
+	if p.MessageID == 0 {
+		return newProtocolError(ErrCodeInvalidPayload, "message_id required")
+	}
+
+	m, err := hub.DeleteMessage(p.MessageID, c.UserID, p.Scope, c)
+	switch err {
+	case nil:
+	case model.ErrInvalidDeleteScope, model.ErrDeleteWindowPassed:
+		return newProtocolError(ErrCodeInvalidPayload, "%v", err)
+	case model.ErrMessageNotFound:
+		return newProtocolError(ErrCodeNotFound, "%v", err)
+	case model.ErrNotMessageSender, model.ErrNotConversationMember:
+		return newProtocolError(ErrCodeForbidden, "%v", err)
+	default:
+		return err
+	}
+
+	c.SendEnvelope(EventMessageDeleted, env.ID, MessageDeletedPayload{
+		MessageID:      m.ID,
+		ConversationID: m.ConversationID,
+		Scope:          p.Scope,
+		DeletedAt:      m.DeletedAt,
+	})
+	return nil
+}
+
+// handleReaction adds or removes an emoji reaction and acknowledges it with
+// reaction.added / reaction.removed
+func handleReaction(hub *Hub, c *Connection, env Envelope) error {
+	var p struct {
+		MessageID int    `json:"message_id"`
+		Emoji     string `json:"emoji"`
+	}
+	if err := decodePayload(env, &p); err != nil {
+		return err
+	}
+	if p.MessageID == 0 {
+		return newProtocolError(ErrCodeInvalidPayload, "message_id required")
+	}
+
+	var r *model.Reaction
+	var err error
+	reply := EventReactionAdded
+	if env.Type == EventReactionAdd {
+		r, err = hub.AddReaction(p.MessageID, c.UserID, p.Emoji, c)
+	} else {
+		r, err = hub.RemoveReaction(p.MessageID, c.UserID, p.Emoji, c)
+		reply = EventReactionRemoved
+	}
+
+	switch err {
+	case nil:
+	case model.ErrInvalidEmoji, model.ErrMessageDeleted:
+		return newProtocolError(ErrCodeInvalidPayload, "%v", err)
+	case model.ErrMessageNotFound:
+		return newProtocolError(ErrCodeNotFound, "%v", err)
+	case model.ErrNotConversationMember:
+		return newProtocolError(ErrCodeForbidden, "%v", err)
+	default:
+		return err
+	}
+
+	c.SendEnvelope(reply, env.ID, r)
+	return nil
+}
+
+// handleRead marks the conversation read up to message_id and acks with
+// read.ok once the receipts are stored
+func handleRead(hub *Hub, c *Connection, env Envelope) error {
+	var p struct {
+		ConversationID int `json:"conversation_id"`
+		MessageID      int `json:"message_id"`
+	}
+	if err := decodePayload(env, &p); err != nil {
+		return err
+	}
+	if p.ConversationID == 0 || p.MessageID == 0 {
+		return newProtocolError(ErrCodeInvalidPayload, "conversation_id and message_id required")
+	}
+
+	if err := hub.MarkRead(c.UserID, p.ConversationID, p.MessageID); err != nil {
+		return err
+	}
+	c.SendEnvelope(EventReadOK, env.ID, p)
+	return nil
+}
+
+// handleTypingStart marks the user as typing in a conversation. Events over
+// the connection's rate limit are dropped silently.
+func handleTypingStart(hub *Hub, c *Connection, env Envelope) error {
+	conversationID, err := decodeTyping(env)
+	if err != nil {
+		return err
+	}
+	if !c.typingLimit.allow() {
+		return nil
+	}
+
+	err = hub.StartTyping(c, conversationID)
+	if err == model.ErrNotConversationMember {
+		return newProtocolError(ErrCodeForbidden, "%v", err)
+	}
+	return err
+}
+
+// handleTypingStop clears the user's typing indicator in a conversation
+func handleTypingStop(hub *Hub, c *Connection, env Envelope) error {
+	conversationID, err := decodeTyping(env)
+	if err != nil {
+		return err
+	}
+	if !c.typingLimit.allow() {
+		return nil
+	}
+
+	hub.StopTyping(c, conversationID)
+	return nil
+}
+
+func decodeTyping(env Envelope) (int, error) {
+	var p struct {
+		ConversationID int `json:"conversation_id"`
+	}
+	if err := decodePayload(env, &p); err != nil {
+		return 0, err
+	}
+	if p.ConversationID == 0 {
+		return 0, newProtocolError(ErrCodeInvalidPayload, "conversation_id required")
+	}
+	return p.ConversationID, nil
+}
+
+// handlePresenceSet stores the user's chosen presence state and custom status
+// and acks with presence.status
+func handlePresenceSet(hub *Hub, c *Connection, env Envelope) error {
+	var s redis.Status
+	if err := decodePayload(env, &s); err != nil {
+		return err
+	}
+
+	if err := hub.SetStatus(c.UserID, &s); err != nil {
+		switch err {
+		case redis.ErrInvalidPresenceState, redis.ErrStatusTextLength:
+			return newProtocolError(ErrCodeInvalidPayload, "%v", err)
+		}
+		return err
+	}
+
+	c.SendEnvelope(EventPresenceStatus, env.ID, s)
+	return nil
+}
+
+// handlePresenceSubscribe starts presence.changed pushes for a set of users and
+// replies with their current presence
+func handlePresenceSubscribe(hub *Hub, c *Connection, env Envelope) error {
+	userIDs, err := decodePresenceUsers(env)
+	if err != nil {
+		return err
+	}
+
+	presences, err := hub.SubscribePresence(c, userIDs)
+	if err == ErrTooManySubscriptions {
+		return newProtocolError(ErrCodeInvalidPayload, "at most %d presence subscriptions per connection", maxPresenceSubscriptions)
+	}
+	if err != nil {
+		return err
+	}
+
+	c.SendEnvelope(EventPresenceSnapshot, env.ID, PresenceSnapshotPayload{Presences: presences})
+	return nil
+}
+
+// handlePresenceUnsubscribe stops presence pushes for a set of users
+func handlePresenceUnsubscribe(hub *Hub, c *Connection, env Envelope) error {
+	userIDs, err := decodePresenceUsers(env)
+	if err != nil {
+		return err
+	}
+
+	hub.UnsubscribePresence(c, userIDs)
+	return nil
+}
+
+func decodePresenceUsers(env Envelope) ([]string, error) {
+	var p struct {
+		UserIDs []string `json:"user_ids"`
+	}
+	if err := decodePayload(env, &p); err != nil {
+		return nil, err
+	}
+	if len(p.UserIDs) == 0 {
+		return nil, newProtocolError(ErrCodeInvalidPayload, "user_ids required")
+	}
+	return p.UserIDs, nil
+}
+
+// handlePing answers with a pong carrying the same request ID
+func handlePing(hub *Hub, c *Connection, env Envelope) error {
+	c.SendEnvelope(EventPong, env.ID, nil)
+	return nil
+}
@@ -2,49 +2,511 @@ package websocket
 
 import (
 	"log"
+	"messaging-service/internal/model"
 	"messaging-service/internal/redis"
 	"sync"
+	"time"
 )
 
-// Hub maintains active WebSocket connections.
+// replayTimeout bounds how long a replay waits for a slow client to drain its buffer
+const replayTimeout = 10 * time.Second
+
+// Hub maintains active WebSocket connections. A user may hold several
+// connections at once (one per device or tab), keyed by connection ID.
+// With a cluster bus attached, deliveries also reach connections held by
+// other instances.
 type Hub struct {
-	connections map[string]*Connection
+	connections map[string]map[string]*Connection
 	mu          sync.RWMutex
+	bus         *redis.Bus
+	busMu       sync.Mutex // serializes bus subscription changes
+	presenceMu  sync.Mutex // keeps heartbeats from reviving closed connections
+	dispatcher  *Dispatcher
+	typing      *typingTracker
+	watchers    *presenceWatchers
+	idleAfter   time.Duration
+	eventLog    bool
 }
 
 func NewHub() *Hub {
-	return &Hub{
-		connections: make(map[string]*Connection),
+	h := &Hub{
+		connections: make(map[string]map[string]*Connection),
+		dispatcher:  NewDispatcher(),
+		typing:      newTypingTracker(),
+		watchers:    newPresenceWatchers(),
+	}
+	registerDefaultHandlers(h.dispatcher)
+	return h
+}
+
+// Handle registers a handler for a client event type on this hub's dispatcher
+func (h *Hub) Handle(eventType string, handler HandlerFunc) {
+	h.dispatcher.Handle(eventType, handler)
+}
+
+// EnableClusterBus attaches a Redis Pub/Sub bus so messages reach users
+// connected to other instances. It must be called before connections register.
+func (h *Hub) EnableClusterBus(nodeID string) {
+	h.bus = redis.NewBus(redis.Client, nodeID, func(d redis.Delivery) {
+		if h.sendLocal(d.UserID, nil, d.MessageID, d.Data) && d.MessageID != 0 {
+			h.markDelivered(d.UserID, []int{d.MessageID})
+		}
+	})
+	if err := h.bus.OnPresence(h.pushPresence); err != nil {
+		log.Println("error subscribing to presence changes:", err)
 	}
 }
 
+// Register adds a connection for the user. Messages queued while the user was
+// offline are replayed on the new connection, in order, before live traffic:
+// live frames arriving during the replay are held back and follow it, minus
+// the messages the replay already sent.
 func (h *Hub) Register(userID string, conn *Connection, userData redis.OnlineUser) {
+	conn.UserID = userID
+	conn.hub = h
+	conn.holdLive()
+
+	h.register(userID, conn, userData)
+	go h.presenceChanged(userID)
+
+	replayed, complete := h.replayUndelivered(conn)
+	if !complete {
+		// Live frames must not overtake the messages left unsent; reconnecting
+		// replays them
+		conn.closeSlow()
+	}
+	h.markDelivered(userID, conn.releaseLive(replayed))
+}
+
+func (h *Hub) register(userID string, conn *Connection, userData redis.OnlineUser) {
+	conn.user = userData
+
 	h.mu.Lock()
-	defer h.mu.Unlock()
-	h.connections[userID] = conn
+	devices, ok := h.connections[userID]
+	if !ok {
+		devices = make(map[string]*Connection)
+		h.connections[userID] = devices
+	}
+	devices[conn.ID] = conn
+	h.mu.Unlock()
 
-	// Mark user as online with user data
-	if err := redis.MarkOnline(userID, userData); err != nil {
+	// Every connection is online on its own, kept alive by heartbeats
+	if err := redis.MarkConnectionOnline(userID, conn.ID, userData); err != nil {
 		log.Println("error marking user as online:", err)
 	}
+	h.syncBusSubscription(userID)
 }
 
-func (h *Hub) Unregister(userID string) {
+// Unregister removes a single connection and closes its send channel. The user
+// stays online while any other connection, here or on another instance, lives.
+func (h *Hub) Unregister(conn *Connection) {
+	h.clearTyping(conn)
+	h.clearPresenceSubscriptions(conn)
+
+	// Only the map changes under the lock; Redis calls happen after it
 	h.mu.Lock()
-	defer h.mu.Unlock()
-	delete(h.connections, userID)
+	devices, ok := h.connections[conn.UserID]
+	if !ok {
+		h.mu.Unlock()
+		return
+	}
+	if _, ok := devices[conn.ID]; !ok {
+		h.mu.Unlock()
+		return
+	}
+	delete(devices, conn.ID)
+	last := len(devices) == 0
+	if last {
+		delete(h.connections, conn.UserID)
+	}
+	h.mu.Unlock()
 
-	// Mark user as offline
-	if err := redis.MarkOffline(userID); err != nil {
+	// Senders look the connection up under the read lock, so none can still
+	// be writing to it
+	close(conn.SendChan)
+
+	// A heartbeat that saw the connection finishes its refresh first
+	h.presenceMu.Lock()
+	if err := redis.MarkConnectionOffline(conn.UserID, conn.ID); err != nil {
 		log.Println("error marking user as offline:", err)
 	}
+	h.presenceMu.Unlock()
+
+	if !last {
+		// The closed device may have been the only active one
+		go h.presenceChanged(conn.UserID)
+		return
+	}
+	go func() {
+		h.recordLastSeen([]string{conn.UserID})
+		h.presenceChanged(conn.UserID)
+	}()
+	h.syncBusSubscription(conn.UserID)
+}
+
+// syncBusSubscription subscribes the cluster bus to the user while this
+// instance holds a connection of theirs and unsubscribes it otherwise.
+// busMu keeps a concurrent register and Unregister from applying their
+// changes out of order.
+func (h *Hub) syncBusSubscription(userID string) {
+	if h.bus == nil {
+		return
+	}
+	h.busMu.Lock()
+	defer h.busMu.Unlock()
+
+	h.mu.RLock()
+	connected := len(h.connections[userID]) > 0
+	h.mu.RUnlock()
+
+	if connected {
+		if err := h.bus.Subscribe(userID); err != nil {
+			log.Println("error subscribing to cluster bus:", err)
+		}
+		return
+	}
+	if err := h.bus.Unsubscribe(userID); err != nil {
+		log.Println("error unsubscribing from cluster bus:", err)
+	}
 }
 
+// SendMessage delivers a message to every connection of the receiver, on this
+// instance and, through the cluster bus, on every other one
 func (h *Hub) SendMessage(receiverID string, message []byte) {
+	h.sendLocal(receiverID, nil, 0, message)
+	h.publishMessage(receiverID, 0, message)
+}
+
+// SendToOtherDevices delivers a message to every connection of the user except
+// the originating one, keeping the user's other devices in sync
+func (h *Hub) SendToOtherDevices(userID string, origin *Connection, message []byte) {
+	h.sendLocal(userID, origin, 0, message)
+	h.publishMessage(userID, 0, message)
+}
+
+// DeliverMessage sends a chat message to every other member of its
+// conversation and to the sender's other devices. Recipients that are offline
+// keep a pending receipt and get the message replayed when they reconnect.
+func (h *Hub) DeliverMessage(m *model.Message, origin *Connection) error {
+	memberIDs, err := model.GetConversationMemberIDs(m.ConversationID)
+	if err != nil {
+		return err
+	}
+
+	data, err := EncodeEnvelope(EventMessageNew, "", m)
+	if err != nil {
+		return err
+	}
+
+	for _, memberID := range memberIDs {
+		if memberID == m.SenderID {
+			continue
+		}
+		if h.sendLocal(memberID, nil, m.ID, data) {
+			h.markDelivered(memberID, []int{m.ID})
+		}
+		h.publishMessage(memberID, m.ID, data)
+	}
+
+	// Keep the sender's other devices in sync
+	h.SendToOtherDevices(m.SenderID, origin, data)
+	h.recordEvent(EventMessageNew, m.ConversationID, memberIDs, m)
+
+	if m.ParentID != 0 {
+		h.notifyThreadParticipants(m)
+	}
+	return nil
+}
+
+// notifyThreadParticipants pushes thread.reply to everyone taking part in the
+// reply's thread, except the replier
+func (h *Hub) notifyThreadParticipants(m *model.Message) {
+	root, err := model.GetMessageByID(m.ParentID)
+	if err != nil {
+		log.Println("error loading thread root:", err)
+		return
+	}
+	participantIDs, err := model.GetThreadParticipantIDs(root.ID)
+	if err != nil {
+		log.Println("error loading thread participants:", err)
+		return
+	}
+
+	payload := ThreadReplyPayload{
+		RootID:         root.ID,
+		ConversationID: root.ConversationID,
+		ReplyCount:     root.ReplyCount,
+		LastReplyAt:    m.CreatedAt,
+		Message:        m,
+	}
+	if root.LastReplyAt != nil {
+		payload.LastReplyAt = *root.LastReplyAt
+	}
+	data, err := EncodeEnvelope(EventThreadReply, "", payload)
+	if err != nil {
+		return
+	}
+
+	for _, userID := range participantIDs {
+		if userID != m.SenderID {
+			h.SendMessage(userID, data)
+		}
+	}
+}
+
+// replayUndelivered sends the user's queued messages to the connection and
+// records their delivery. It returns the IDs of the messages sent and whether
+// all of them were.
+func (h *Hub) replayUndelivered(conn *Connection) (map[int]bool, bool) {
+	replayed := make(map[int]bool)
+	messages, err := model.GetUndeliveredMessages(conn.UserID)
+	if err != nil {
+		log.Println("error loading undelivered messages:", err)
+		return replayed, false
+	}
+
+	var sent []int
+	for i := range messages {
+		data, err := EncodeEnvelope(EventMessageNew, "", messages[i])
+		if err != nil {
+			continue
+		}
+		if !conn.sendWithTimeout(data, replayTimeout) {
+			h.markDelivered(conn.UserID, sent)
+			return replayed, false
+		}
+		sent = append(sent, messages[i].ID)
+		replayed[messages[i].ID] = true
+	}
+
+	h.markDelivered(conn.UserID, sent)
+	return replayed, true
+}
+
+// resendSpilled resends, in order, the chat messages a connection spilled
+// while its send buffer was full, then lets live frames through again
+func (h *Hub) resendSpilled(conn *Connection) {
+	ids := conn.takeSpilled()
+	messages, err := model.GetMessagesByIDs(ids)
+	if err != nil {
+		log.Println("error loading spilled messages:", err)
+		// Reconnecting replays what is still undelivered
+		conn.closeSlow()
+		return
+	}
+
+	ids = ids[:0]
+	frames := make([][]byte, 0, len(messages))
+	for i := range messages {
+		data, err := EncodeEnvelope(EventMessageNew, "", messages[i])
+		if err != nil {
+			continue
+		}
+		ids = append(ids, messages[i].ID)
+		frames = append(frames, data)
+	}
+
+	h.mu.RLock()
+	var sent []int
+	if _, ok := h.connections[conn.UserID][conn.ID]; ok {
+		sent = conn.releaseSpilled(ids, frames)
+	}
+	h.mu.RUnlock()
+
+	h.markDelivered(conn.UserID, sent)
+}
+
+// markDelivered records delivery to the user and acknowledges it to each sender
+func (h *Hub) markDelivered(userID string, messageIDs []int) {
+	receipts, err := model.MarkDelivered(userID, messageIDs)
+	if err != nil {
+		log.Println("error marking messages delivered:", err)
+		return
+	}
+	h.notifySenders(receipts)
+}
+
+// MarkRead records that the user has read the conversation up to and including
+// upToMessageID and pushes a "read" event to the senders of those messages
+func (h *Hub) MarkRead(userID string, conversationID, upToMessageID int) error {
+	receipts, err := model.MarkReadUpTo(userID, conversationID, upToMessageID)
+	if err != nil {
+		return err
+	}
+	h.notifySenders(receipts)
+	return nil
+}
+
+// notifySenders pushes a receipt event for each status change to the
+// message's sender
+func (h *Hub) notifySenders(receipts []model.ReceiptUpdate) {
+	for _, r := range receipts {
+		payload := ReceiptPayload{
+			MessageID:      r.MessageID,
+			ConversationID: r.ConversationID,
+			UserID:         r.UserID,
+			Status:         r.Status,
+			At:             r.At.Format(time.RFC3339Nano),
+		}
+		data, err := EncodeEnvelope(EventReceipt, "", payload)
+		if err != nil {
+			continue
+		}
+		h.SendMessage(r.SenderID, data)
+		h.recordEvent(EventReceipt, r.ConversationID, []string{r.SenderID, r.UserID}, payload)
+	}
+}
+
+// BroadcastMessageEdited pushes message.edited to every connection of the
+// conversation's members except origin, which received its own reply
+func (h *Hub) BroadcastMessageEdited(m *model.Message, origin *Connection) error {
+	return h.broadcastToConversation(m.ConversationID, origin, EventMessageEdited, m)
+}
+
+// DeleteMessage deletes a message for the user (scope "me") or for everyone,
+// then pushes message.deleted to the user's other devices or to the whole
+// conversation respectively. origin, if set, is skipped.
+func (h *Hub) DeleteMessage(messageID int, userID, scope string, origin *Connection) (*model.Message, error) {
+	var m *model.Message
+	var err error
+	switch scope {
+	case model.DeleteForMe:
+		m, err = model.HideMessage(messageID, userID)
+	case model.DeleteForEveryone:
+		m, err = model.DeleteMessageForEveryone(messageID, userID)
+	default:
+		return nil, model.ErrInvalidDeleteScope
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	payload := MessageDeletedPayload{
+		MessageID:      m.ID,
+		ConversationID: m.ConversationID,
+		Scope:          scope,
+		DeletedAt:      m.DeletedAt,
+	}
+
+	if scope == model.DeleteForMe {
+		data, err := EncodeEnvelope(EventMessageDeleted, "", payload)
+		if err != nil {
+			return nil, err
+		}
+		h.SendToOtherDevices(userID, origin, data)
+		h.recordEvent(EventMessageDeleted, m.ConversationID, []string{userID}, payload)
+		return m, nil
+	}
+
+	if err := h.broadcastToConversation(m.ConversationID, origin, EventMessageDeleted, payload); err != nil {
+		log.Println("error broadcasting delete:", err)
+	}
+	return m, nil
+}
+
+// AddReaction stores a reaction and pushes reaction.added to the conversation
+func (h *Hub) AddReaction(messageID int, userID, emoji string, origin *Connection) (*model.Reaction, error) {
+	r, changed, err := model.AddReaction(messageID, userID, emoji)
+	if err != nil {
+		return nil, err
+	}
+	if changed {
+		if err := h.broadcastToConversation(r.ConversationID, origin, EventReactionAdded, r); err != nil {
+			log.Println("error broadcasting reaction:", err)
+		}
+	}
+	return r, nil
+}
+
+// RemoveReaction deletes a reaction and pushes reaction.removed to the conversation
+func (h *Hub) RemoveReaction(messageID int, userID, emoji string, origin *Connection) (*model.Reaction, error) {
+	r, changed, err := model.RemoveReaction(messageID, userID, emoji)
+	if err != nil {
+		return nil, err
+	}
+	if changed {
+		if err := h.broadcastToConversation(r.ConversationID, origin, EventReactionRemoved, r); err != nil {
+			log.Println("error broadcasting reaction:", err)
+		}
+	}
+	return r, nil
+}
+
+// broadcastToConversation pushes an event to every member of a conversation,
+// including the acting user's other devices, skipping the origin connection
+func (h *Hub) broadcastToConversation(conversationID int, origin *Connection, eventType string, payload interface{}) error {
+	memberIDs, err := model.GetConversationMemberIDs(conversationID)
+	if err != nil {
+		return err
+	}
+
+	data, err := EncodeEnvelope(eventType, "", payload)
+	if err != nil {
+		return err
+	}
+
+	for _, memberID := range memberIDs {
+		if origin != nil && memberID == origin.UserID {
+			h.SendToOtherDevices(memberID, origin, data)
+			continue
+		}
+		h.SendMessage(memberID, data)
+	}
+	h.recordEvent(eventType, conversationID, memberIDs, payload)
+	return nil
+}
+
+// SendEventToConversation pushes an event to every member of a conversation
+// except skipUserID, on this instance and through the cluster bus
+func (h *Hub) SendEventToConversation(conversationID int, skipUserID, eventType string, payload interface{}) error {
+	memberIDs, err := model.GetConversationMemberIDs(conversationID)
+	if err != nil {
+		return err
+	}
+
+	data, err := EncodeEnvelope(eventType, "", payload)
+	if err != nil {
+		return err
+	}
+
+	for _, memberID := range memberIDs {
+		if memberID == skipUserID {
+			continue
+		}
+		h.SendMessage(memberID, data)
+	}
+	return nil
+}
+
+// sendLocal writes a message to the user's connections on this instance,
+// skipping except if set. messageID is set for chat messages. It reports
+// whether any connection was found.
+func (h *Hub) sendLocal(userID string, except *Connection, messageID int, message []byte) bool {
 	h.mu.RLock()
 	defer h.mu.RUnlock()
-	if conn, ok := h.connections[receiverID]; ok {
-		conn.Send(message)
+
+	sent := false
+	for id, conn := range h.connections[userID] {
+		if except != nil && id == except.ID {
+			continue
+		}
+		if conn.sendFrame(messageID, message) {
+			sent = true
+		}
+	}
+	return sent
+}
+
+// publishMessage forwards a frame to the user's connections on other
+// instances; messageID is set for chat messages. A user may be connected to
+// several instances at once (multi-device), so deliveries are published even
+// when the user also has local connections.
+func (h *Hub) publishMessage(userID string, messageID int, message []byte) {
+	if h.bus == nil {
+		return
+	}
+	if err := h.bus.Publish(userID, messageID, message); err != nil {
+		log.Println("error publishing to cluster bus:", err)
 	}
 }
 
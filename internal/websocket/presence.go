@@ -0,0 +1,302 @@
+package websocket
+
+import (
+	"errors"
+	"log"
+	"messaging-service/internal/model"
+	"messaging-service/internal/redis"
+	"sync"
+	"time"
+)
+
+// StartPresence runs the presence heartbeat: every third of redis.PresenceTTL
+// it refreshes the presence keys of this instance's connections, marks
+// connections without client activity for idleAfter as idle, records
+// last_seen_at and reaps entries left behind by instances that stopped
+// heartbeating.
+func (h *Hub) StartPresence(idleAfter time.Duration) {
+	h.idleAfter = idleAfter
+
+	// Entries written before presence had a TTL would never expire
+	if err := redis.RemoveLegacyPresence(); err != nil {
+		log.Println("error removing legacy presence keys:", err)
+	}
+
+	go func() {
+		ticker := time.NewTicker(redis.PresenceTTL / 3)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			h.heartbeat()
+		}
+	}()
+}
+
+func (h *Hub) heartbeat() {
+	now := time.Now()
+
+	// Held until the refresh is written, so a connection unregistered after
+	// the snapshot is marked offline after its refresh, not before
+	h.presenceMu.Lock()
+	h.mu.RLock()
+	var conns []redis.ConnectionPresence
+	var userIDs, idled []string
+	for userID, devices := range h.connections {
+		userIDs = append(userIDs, userID)
+		for _, conn := range devices {
+			if h.idleAfter > 0 && now.Sub(time.Unix(0, conn.lastActivity.Load())) > h.idleAfter &&
+				conn.idle.CompareAndSwap(false, true) {
+				idled = append(idled, userID)
+			}
+			conns = append(conns, conn.presence())
+		}
+	}
+	h.mu.RUnlock()
+
+	if err := redis.RefreshPresence(conns); err != nil {
+		log.Println("error refreshing presence:", err)
+	}
+	h.presenceMu.Unlock()
+
+	reaped, err := redis.ReapPresence()
+	if err != nil {
+		log.Println("error reaping presence:", err)
+	} else if len(reaped) > 0 {
+		log.Printf("Reaped stale presence entries of %d users", len(reaped))
+	}
+
+	h.recordLastSeen(userIDs)
+
+	// Users going idle may turn away; reaped users may have gone offline
+	for _, userID := range append(idled, reaped...) {
+		h.presenceChanged(userID)
+	}
+}
+
+// touch records client activity on a connection. A connection coming back
+// from idle is refreshed right away so the user shows as online again.
+func (h *Hub) touch(c *Connection) {
+	c.lastActivity.Store(time.Now().UnixNano())
+	if c.idle.CompareAndSwap(true, false) {
+		if err := redis.RefreshPresence([]redis.ConnectionPresence{c.presence()}); err != nil {
+			log.Println("error refreshing presence:", err)
+		}
+		h.presenceChanged(c.UserID)
+	}
+}
+
+// presence returns the connection's entry for the presence heartbeat
+func (c *Connection) presence() redis.ConnectionPresence {
+	user := c.user
+	user.Idle = c.idle.Load()
+	return redis.ConnectionPresence{UserID: c.UserID, ConnID: c.ID, User: user}
+}
+
+// recordLastSeen persists last_seen_at for connected users. Invisible users
+// are skipped so their last-seen time does not give them away.
+func (h *Hub) recordLastSeen(userIDs []string) {
+	if len(userIDs) == 0 {
+		return
+	}
+
+	statuses, err := redis.GetStatuses(userIDs)
+	if err != nil {
+		log.Println("error loading presence statuses:", err)
+		return
+	}
+	var visible []string
+	for _, userID := range userIDs {
+		if statuses[userID].State != redis.PresenceInvisible {
+			visible = append(visible, userID)
+		}
+	}
+
+	if err := model.RecordLastSeen(visible); err != nil {
+		log.Println("error recording last seen:", err)
+	}
+}
+
+// SetStatus stores the presence state and custom status a user chose
+func (h *Hub) SetStatus(userID string, s *redis.Status) error {
+	if err := s.Validate(); err != nil {
+		return err
+	}
+	if err := redis.SetStatus(userID, *s); err != nil {
+		return err
+	}
+	h.presenceChanged(userID)
+	return nil
+}
+
+// GetPresence returns a user's presence as others see it, with the last-seen
+// time when they are offline
+func GetPresence(userID string) (redis.Presence, error) {
+	p, err := redis.GetPresence(userID)
+	if err != nil {
+		return p, err
+	}
+	if p.State == redis.PresenceOffline {
+		p.LastSeenAt, err = model.GetLastSeen(userID)
+	}
+	return p, err
+}
+
+// maxPresenceSubscriptions bounds how many users one connection may watch
+const maxPresenceSubscriptions = 500
+
+var ErrTooManySubscriptions = errors.New("too many presence subscriptions")
+
+// presenceWatchers tracks which local connections subscribed to whose
+// presence, and the last presence pushed for each watched user so unchanged
+// states are not pushed twice
+type presenceWatchers struct {
+	mu     sync.Mutex
+	byUser map[string]map[*Connection]bool
+	byConn map[*Connection]map[string]bool
+	last   map[string]redis.Presence
+}
+
+func newPresenceWatchers() *presenceWatchers {
+	return &presenceWatchers{
+		byUser: make(map[string]map[*Connection]bool),
+		byConn: make(map[*Connection]map[string]bool),
+		last:   make(map[string]redis.Presence),
+	}
+}
+
+// SubscribePresence starts pushing presence.changed to the connection for the
+// given users and returns their current presence
+func (h *Hub) SubscribePresence(c *Connection, userIDs []string) ([]redis.Presence, error) {
+	w := h.watchers
+	w.mu.Lock()
+	watched := w.byConn[c]
+	if watched == nil {
+		watched = make(map[string]bool)
+		w.byConn[c] = watched
+	}
+	added := 0
+	for _, userID := range userIDs {
+		if !watched[userID] {
+			added++
+		}
+	}
+	if len(watched)+added > maxPresenceSubscriptions {
+		w.mu.Unlock()
+		return nil, ErrTooManySubscriptions
+	}
+	for _, userID := range userIDs {
+		watched[userID] = true
+		if w.byUser[userID] == nil {
+			w.byUser[userID] = make(map[*Connection]bool)
+		}
+		w.byUser[userID][c] = true
+	}
+	w.mu.Unlock()
+
+	presences := make([]redis.Presence, 0, len(userIDs))
+	for _, userID := range userIDs {
+		p, err := GetPresence(userID)
+		if err != nil {
+			return nil, err
+		}
+		presences = append(presences, p)
+
+		w.mu.Lock()
+		if _, ok := w.last[userID]; !ok {
+			w.last[userID] = p
+		}
+		w.mu.Unlock()
+	}
+	return presences, nil
+}
+
+// UnsubscribePresence stops presence pushes for the given users
+func (h *Hub) UnsubscribePresence(c *Connection, userIDs []string) {
+	w := h.watchers
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, userID := range userIDs {
+		w.unwatch(c, userID)
+	}
+}
+
+// clearPresenceSubscriptions drops every subscription of a closed connection
+func (h *Hub) clearPresenceSubscriptions(c *Connection) {
+	w := h.watchers
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for userID := range w.byConn[c] {
+		w.unwatch(c, userID)
+	}
+}
+
+// unwatch removes one subscription; callers hold w.mu
+func (w *presenceWatchers) unwatch(c *Connection, userID string) {
+	delete(w.byConn[c], userID)
+	if len(w.byConn[c]) == 0 {
+		delete(w.byConn, c)
+	}
+	delete(w.byUser[userID], c)
+	if len(w.byUser[userID]) == 0 {
+		delete(w.byUser, userID)
+		delete(w.last, userID)
+	}
+}
+
+// presenceChanged recomputes a user's presence after one of their connections
+// or their status changed, and pushes it to subscribers on every instance
+func (h *Hub) presenceChanged(userID string) {
+	p, err := GetPresence(userID)
+	if err != nil {
+		log.Println("error loading presence:", err)
+		return
+	}
+
+	h.pushPresence(p)
+	h.recordEvent(EventPresenceChanged, 0, []string{userID}, p)
+	if h.bus != nil {
+		if err := h.bus.PublishPresence(p); err != nil {
+			log.Println("error publishing presence:", err)
+		}
+	}
+}
+
+// pushPresence sends presence.changed to local subscribers of the user,
+// unless the visible presence did not change since the last push
+func (h *Hub) pushPresence(p redis.Presence) {
+	w := h.watchers
+	w.mu.Lock()
+	conns := make([]*Connection, 0, len(w.byUser[p.UserID]))
+	for c := range w.byUser[p.UserID] {
+		conns = append(conns, c)
+	}
+	if len(conns) == 0 || samePresence(w.last[p.UserID], p) {
+		w.mu.Unlock()
+		return
+	}
+	w.last[p.UserID] = p
+	w.mu.Unlock()
+
+	data, err := EncodeEnvelope(EventPresenceChanged, "", p)
+	if err != nil {
+		return
+	}
+
+	// Unregister removes connections under the write lock before closing
+	// their send channels
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for _, c := range conns {
+		if _, ok := h.connections[c.UserID][c.ID]; ok {
+			c.Send(data)
+		}
+	}
+}
+
+func samePresence(a, b redis.Presence) bool {
+	sameExpiry := (a.TextExpiresAt == nil) == (b.TextExpiresAt == nil) &&
+		(a.TextExpiresAt == nil || a.TextExpiresAt.Equal(*b.TextExpiresAt))
+	return a.UserID == b.UserID && a.State == b.State && a.Text == b.Text && sameExpiry
+}
@@ -0,0 +1,177 @@
+package websocket
+
+import (
+	"encoding/json"
+	"fmt"
+	"messaging-service/internal/redis"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	goredis "github.com/go-redis/redis/v8"
+)
+
+// startRedis points the redis package at an in-process Redis for the test
+func startRedis(t *testing.T) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	redis.Client = goredis.NewClient(&goredis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { redis.Client.Close() })
+}
+
+// waitFor polls cond until it holds or a second passes
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// goOnline gives the user a live connection in Redis, so their presence is
+// not offline (which would look up last_seen_at in Postgres)
+func goOnline(t *testing.T, userID string) {
+	t.Helper()
+	if err := redis.MarkConnectionOnline(userID, "c-"+userID, redis.OnlineUser{ID: userID}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// presenceFrames returns the presence.changed payloads queued on a connection
+func presenceFrames(t *testing.T, c *Connection) []redis.Presence {
+	t.Helper()
+	var presences []redis.Presence
+	for _, f := range drain(c) {
+		var env Envelope
+		if err := json.Unmarshal([]byte(f), &env); err != nil {
+			t.Fatal(err)
+		}
+		if env.Type != EventPresenceChanged {
+			t.Fatalf("unexpected frame %s", f)
+		}
+		var p redis.Presence
+		if err := json.Unmarshal(env.Payload, &p); err != nil {
+			t.Fatal(err)
+		}
+		presences = append(presences, p)
+	}
+	return presences
+}
+
+func TestPresencePushSkipsUnchangedPresence(t *testing.T) {
+	startRedis(t)
+	goOnline(t, "bob")
+	h := NewHub()
+	watcher := connect(h, "alice", "a1")
+
+	snapshot, err := h.SubscribePresence(watcher, []string{"bob"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshot) != 1 || snapshot[0].State != redis.PresenceOnline {
+		t.Fatalf("snapshot %+v", snapshot)
+	}
+
+	// Nothing visible changed since the snapshot
+	h.presenceChanged("bob")
+	if got := presenceFrames(t, watcher); len(got) != 0 {
+		t.Fatalf("unchanged presence pushed: %+v", got)
+	}
+
+	if err := h.SetStatus("bob", &redis.Status{State: redis.PresenceDND}); err != nil {
+		t.Fatal(err)
+	}
+	h.presenceChanged("bob")
+	if err := h.SetStatus("bob", &redis.Status{State: redis.PresenceDND, Text: "focus"}); err != nil {
+		t.Fatal(err)
+	}
+	got := presenceFrames(t, watcher)
+	if len(got) != 2 || got[0].State != redis.PresenceDND || got[0].Text != "" || got[1].Text != "focus" {
+		t.Fatalf("pushed %+v, want dnd then dnd with text", got)
+	}
+}
+
+func TestPresenceSubscriptionsEnd(t *testing.T) {
+	startRedis(t)
+	goOnline(t, "bob")
+	goOnline(t, "carol")
+	h := NewHub()
+	watcher := connect(h, "alice", "a1")
+
+	if _, err := h.SubscribePresence(watcher, []string{"bob", "carol"}); err != nil {
+		t.Fatal(err)
+	}
+	h.UnsubscribePresence(watcher, []string{"bob"})
+	h.SetStatus("bob", &redis.Status{State: redis.PresenceAway})
+	h.SetStatus("carol", &redis.Status{State: redis.PresenceAway})
+	if got := presenceFrames(t, watcher); len(got) != 1 || got[0].UserID != "carol" {
+		t.Fatalf("after unsubscribing from bob, pushed %+v", got)
+	}
+
+	// Unregister drops every subscription of the closed connection
+	h.clearPresenceSubscriptions(watcher)
+	h.SetStatus("carol", &redis.Status{State: redis.PresenceDND})
+	if got := presenceFrames(t, watcher); len(got) != 0 {
+		t.Fatalf("closed connection got %+v", got)
+	}
+	w := h.watchers
+	if len(w.byUser) != 0 || len(w.byConn) != 0 || len(w.last) != 0 {
+		t.Fatalf("subscriptions left behind: %v %v %v", w.byUser, w.byConn, w.last)
+	}
+}
+
+func TestPresenceSubscriptionLimit(t *testing.T) {
+	startRedis(t)
+	h := NewHub()
+	watcher := connect(h, "alice", "a1")
+
+	userIDs := make([]string, maxPresenceSubscriptions+1)
+	for i := range userIDs {
+		userIDs[i] = fmt.Sprint(i + 1)
+	}
+	if _, err := h.SubscribePresence(watcher, userIDs); err != ErrTooManySubscriptions {
+		t.Fatalf("got %v, want ErrTooManySubscriptions", err)
+	}
+	if len(h.watchers.byUser) != 0 {
+		t.Fatal("a refused subscription was partly kept")
+	}
+}
+
+func TestPresencePropagatesAcrossInstances(t *testing.T) {
+	startRedis(t)
+	goOnline(t, "bob")
+
+	a, b := NewHub(), NewHub()
+	a.EnableClusterBus("node-a")
+	b.EnableClusterBus("node-b")
+	t.Cleanup(func() {
+		a.bus.Close()
+		b.bus.Close()
+	})
+	waitFor(t, "presence subscriptions of both buses", func() bool {
+		counts, err := redis.Client.PubSubNumSub(redis.Ctx, "presence").Result()
+		return err == nil && counts["presence"] == 2
+	})
+
+	watcher := connect(b, "alice", "a1")
+	if _, err := b.SubscribePresence(watcher, []string{"bob"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// bob's status changes on the instance holding his connection
+	if err := a.SetStatus("bob", &redis.Status{State: redis.PresenceDND}); err != nil {
+		t.Fatal(err)
+	}
+	// A second, identical notice is not pushed again
+	a.presenceChanged("bob")
+
+	waitFor(t, "the presence change on the other instance", func() bool { return len(watcher.SendChan) > 0 })
+	time.Sleep(50 * time.Millisecond)
+	got := presenceFrames(t, watcher)
+	if len(got) != 1 || got[0].UserID != "bob" || got[0].State != redis.PresenceDND {
+		t.Fatalf("pushed %+v, want bob's dnd once", got)
+	}
+}
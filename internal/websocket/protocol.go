@@ -0,0 +1,151 @@
+package websocket
+
+import (
+	"encoding/json"
+	"fmt"
+	"messaging-service/internal/model"
+	"messaging-service/internal/redis"
+	"time"
+)
+
+// ProtocolVersion is the envelope version spoken by this server
+const ProtocolVersion = 1
+
+// Event types. Client -> server events are handled by the Dispatcher; the
+// server -> client ones are pushed by the Hub.
+const (
+	// client -> server
+	EventMessageSend         = "message.send"
+	EventMessageEdit         = "message.edit"
+	EventMessageDelete       = "message.delete"
+	EventReactionAdd         = "reaction.add"
+	EventReactionRemove      = "reaction.remove"
+	EventRead                = "read"
+	EventTypingStart         = "typing.start"
+	EventTypingStop          = "typing.stop"
+	EventTyping              = "typing" // legacy alias of typing.start
+	EventPresenceSet         = "presence.set"
+	EventPresenceSubscribe   = "presence.subscribe"
+	EventPresenceUnsubscribe = "presence.unsubscribe"
+	EventPing                = "ping"
+
+	// server -> client
+	EventMessageNew       = "message.new"
+	EventMessageSent      = "message.sent"
+	EventMessageEdited    = "message.edited"
+	EventMessageDeleted   = "message.deleted"
+	EventThreadReply      = "thread.reply"
+	EventReactionAdded    = "reaction.added"
+	EventReactionRemoved  = "reaction.removed"
+	EventReceipt          = "receipt"
+	EventReadOK           = "read.ok"
+	EventPresenceStatus   = "presence.status"
+	EventPresenceChanged  = "presence.changed"
+	EventPresenceSnapshot = "presence.snapshot"
+	EventPong             = "pong"
+	EventError            = "error"
+)
+
+// Envelope is the frame exchanged over the WebSocket in both directions.
+// ID is chosen by the client and echoed on the reply (ack or error) so
+// requests can be correlated.
+type Envelope struct {
+	V       int             `json:"v"`
+	Type    string          `json:"type"`
+	ID      string          `json:"id,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// Error codes carried by error frames
+const (
+	ErrCodeInvalidFrame   = "invalid_frame"
+	ErrCodeUnsupported    = "unsupported_version"
+	ErrCodeUnknownType    = "unknown_type"
+	ErrCodeInvalidPayload = "invalid_payload"
+	ErrCodeForbidden      = "forbidden"
+	ErrCodeNotFound       = "not_found"
+	ErrCodeInternal       = "internal_error"
+)
+
+// ProtocolError is returned by handlers to send a structured error frame
+type ProtocolError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *ProtocolError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+func newProtocolError(code, format string, args ...interface{}) *ProtocolError {
+	return &ProtocolError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// ReceiptPayload is pushed to a sender when a recipient's receipt changes status
+type ReceiptPayload struct {
+	MessageID      int    `json:"message_id"`
+	ConversationID int    `json:"conversation_id"`
+	UserID         string `json:"user_id"`
+	Status         string `json:"status"`
+	At             string `json:"at"`
+}
+
+// ThreadReplyPayload notifies thread participants of a new reply together with
+// the root's updated reply stats
+type ThreadReplyPayload struct {
+	RootID         int            `json:"root_id"`
+	ConversationID int            `json:"conversation_id"`
+	ReplyCount     int            `json:"reply_count"`
+	LastReplyAt    time.Time      `json:"last_reply_at"`
+	Message        *model.Message `json:"message"`
+}
+
+// PresenceSnapshotPayload answers presence.subscribe with the current
+// presence of the subscribed users
+type PresenceSnapshotPayload struct {
+	Presences []redis.Presence `json:"presences"`
+}
+
+// MessageDeletedPayload tells clients to remove a message. With scope "me" it
+// only goes to the deleting user's devices.
+type MessageDeletedPayload struct {
+	MessageID      int        `json:"message_id"`
+	ConversationID int        `json:"conversation_id"`
+	Scope          string     `json:"scope"`
+	DeletedAt      *time.Time `json:"deleted_at,omitempty"`
+}
+
+// EncodeEnvelope builds a server frame of the given type. id is the request ID
+// being answered, or empty for pushed events.
+func EncodeEnvelope(eventType, id string, payload interface{}) ([]byte, error) {
+	env := Envelope{V: ProtocolVersion, Type: eventType, ID: id}
+	if payload != nil {
+		raw, err := json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		env.Payload = raw
+	}
+	return json.Marshal(env)
+}
+
+// decodeEnvelope parses a client frame. Frames without a type are treated as
+// a legacy bare chat message and wrapped as message.send.
+func decodeEnvelope(frame []byte) (Envelope, error) {
+	var env Envelope
+	if err := json.Unmarshal(frame, &env); err != nil {
+		return env, newProtocolError(ErrCodeInvalidFrame, "frame is not valid JSON")
+	}
+
+	if env.Type == "" {
+		return Envelope{V: ProtocolVersion, Type: EventMessageSend, Payload: frame}, nil
+	}
+
+	if env.V == 0 {
+		env.V = ProtocolVersion
+	}
+	if env.V != ProtocolVersion {
+		return env, newProtocolError(ErrCodeUnsupported, "protocol version %d is not supported", env.V)
+	}
+	return env, nil
+}
@@ -0,0 +1,140 @@
+package websocket
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestDecodeEnvelope(t *testing.T) {
+	tests := []struct {
+		name     string
+		frame    string
+		wantType string
+		wantID   string
+		wantCode string
+	}{
+		{"versioned", `{"v": 1, "type": "ping", "id": "p-1"}`, EventPing, "p-1", ""},
+		{"version omitted", `{"type": "ping", "id": "p-2"}`, EventPing, "p-2", ""},
+		{"legacy bare message", `{"conversation_id": 1, "content": "hi"}`, EventMessageSend, "", ""},
+		{"future version", `{"v": 2, "type": "ping", "id": "p-3"}`, "", "p-3", ErrCodeUnsupported},
+		{"not JSON", `{"type": "ping"`, "", "", ErrCodeInvalidFrame},
+		{"not an object", `[1, 2]`, "", "", ErrCodeInvalidFrame},
+	}
+	for _, tt := range tests {
+		env, err := decodeEnvelope([]byte(tt.frame))
+		if tt.wantCode != "" {
+			var perr *ProtocolError
+			if !errors.As(err, &perr) || perr.Code != tt.wantCode {
+				t.Errorf("%s: error %v, want code %s", tt.name, err, tt.wantCode)
+			}
+			if env.ID != tt.wantID {
+				t.Errorf("%s: ID %q, want %q to echo on the error", tt.name, env.ID, tt.wantID)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if env.V != ProtocolVersion || env.Type != tt.wantType || env.ID != tt.wantID {
+			t.Errorf("%s: decoded %+v, want type %s and ID %q", tt.name, env, tt.wantType, tt.wantID)
+		}
+	}
+
+	// A legacy frame becomes the payload of message.send as is
+	frame := `{"conversation_id": 1, "content": "hi"}`
+	if env, _ := decodeEnvelope([]byte(frame)); string(env.Payload) != frame {
+		t.Errorf("legacy payload %s, want the whole frame", env.Payload)
+	}
+}
+
+// reply is a server frame with its error payload, if any
+type reply struct {
+	Envelope
+	Error ProtocolError
+}
+
+// dispatch runs one frame through the hub's dispatcher and returns the replies
+func dispatch(t *testing.T, h *Hub, frame string) []reply {
+	t.Helper()
+	c := &Connection{UserID: "1", SendChan: make(chan []byte, 8)}
+	h.dispatcher.Dispatch(h, c, []byte(frame))
+
+	var replies []reply
+	for _, f := range drain(c) {
+		var r reply
+		if err := json.Unmarshal([]byte(f), &r.Envelope); err != nil {
+			t.Fatalf("reply %s: %v", f, err)
+		}
+		if r.V != ProtocolVersion {
+			t.Errorf("reply %s has version %d", f, r.V)
+		}
+		if r.Type == EventError {
+			if err := json.Unmarshal(r.Payload, &r.Error); err != nil {
+				t.Fatalf("error payload %s: %v", r.Payload, err)
+			}
+		}
+		replies = append(replies, r)
+	}
+	return replies
+}
+
+func TestDispatch(t *testing.T) {
+	h := NewHub()
+	h.Handle("test.echo", func(hub *Hub, c *Connection, env Envelope) error {
+		var p struct {
+			Text string `json:"text"`
+		}
+		if err := decodePayload(env, &p); err != nil {
+			return err
+		}
+		c.SendEnvelope("test.echoed", env.ID, p)
+		return nil
+	})
+	h.Handle("test.forbidden", func(hub *Hub, c *Connection, env Envelope) error {
+		return newProtocolError(ErrCodeForbidden, "not yours")
+	})
+	h.Handle("test.broken", func(hub *Hub, c *Connection, env Envelope) error {
+		return errors.New("database is down")
+	})
+
+	tests := []struct {
+		name     string
+		frame    string
+		wantType string
+		wantID   string
+		wantCode string
+	}{
+		{"ping", `{"v": 1, "type": "ping", "id": "a"}`, EventPong, "a", ""},
+		{"handled", `{"v": 1, "type": "test.echo", "id": "b", "payload": {"text": "hi"}}`, "test.echoed", "b", ""},
+		{"missing payload", `{"v": 1, "type": "test.echo", "id": "c"}`, EventError, "c", ErrCodeInvalidPayload},
+		{"invalid payload", `{"v": 1, "type": "test.echo", "id": "d", "payload": {"text": 5}}`, EventError, "d", ErrCodeInvalidPayload},
+		{"built-in handler payload", `{"v": 1, "type": "message.send", "id": "e", "payload": "hi"}`, EventError, "e", ErrCodeInvalidPayload},
+		{"rejected", `{"v": 1, "type": "test.forbidden", "id": "f"}`, EventError, "f", ErrCodeForbidden},
+		{"internal error", `{"v": 1, "type": "test.broken", "id": "g"}`, EventError, "g", ErrCodeInternal},
+		{"unknown type", `{"v": 1, "type": "bogus", "id": "h"}`, EventError, "h", ErrCodeUnknownType},
+		{"unsupported version", `{"v": 9, "type": "ping", "id": "i"}`, EventError, "i", ErrCodeUnsupported},
+		{"malformed", `not json`, EventError, "", ErrCodeInvalidFrame},
+	}
+	for _, tt := range tests {
+		replies := dispatch(t, h, tt.frame)
+		if len(replies) != 1 {
+			t.Errorf("%s: %d replies, want 1", tt.name, len(replies))
+			continue
+		}
+		r := replies[0]
+		if r.Type != tt.wantType || r.ID != tt.wantID || r.Error.Code != tt.wantCode {
+			t.Errorf("%s: reply %s %q code %q, want %s %q code %q",
+				tt.name, r.Type, r.ID, r.Error.Code, tt.wantType, tt.wantID, tt.wantCode)
+		}
+		if r.Type == EventError && r.Error.Message == "" {
+			t.Errorf("%s: error frame without a message", tt.name)
+		}
+	}
+
+	// Internal errors are not leaked to the client
+	if r := dispatch(t, h, `{"type": "test.broken"}`); r[0].Error.Message != "internal server error" {
+		t.Errorf("internal error sent as %q", r[0].Error.Message)
+	}
+}
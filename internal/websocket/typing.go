@@ -0,0 +1,245 @@
+package websocket
+
+import (
+	"log"
+	"messaging-service/internal/model"
+	"sync"
+	"time"
+)
+
+// Typing indicators are ephemeral: they live in memory on the instance the
+// typist is connected to and are never written to Postgres.
+const (
+	// typingTimeout stops an indicator whose client went quiet; clients
+	// repeat typing.start while the user keeps typing
+	typingTimeout = 6 * time.Second
+
+	// typingRelayInterval is the minimum gap between typing.start frames
+	// relayed for the same user and conversation
+	typingRelayInterval = 3 * time.Second
+
+	// memberCacheTTL bounds how stale the member lists used for typing are
+	memberCacheTTL = 30 * time.Second
+
+	// typingRate and typingBurst limit the typing events a connection may
+	// send; excess events are dropped
+	typingRate  = 2.0
+	typingBurst = 5.0
+)
+
+// TypingPayload is relayed with typing.start and typing.stop
+type TypingPayload struct {
+	ConversationID int    `json:"conversation_id"`
+	UserID         string `json:"user_id"`
+}
+
+type typingKey struct {
+	conversationID int
+	userID         string
+}
+
+type typingState struct {
+	connID    string
+	timer     *time.Timer
+	expiresAt time.Time
+	relayedAt time.Time
+}
+
+type cachedMembers struct {
+	ids       []string
+	expiresAt time.Time
+}
+
+// typingTracker holds who is typing where, and a short-lived cache of
+// conversation members so relaying stays off the database. Expired cache
+// entries are swept at most once per memberCacheTTL.
+type typingTracker struct {
+	mu      sync.Mutex
+	active  map[typingKey]*typingState
+	members map[int]cachedMembers
+	sweptAt time.Time
+}
+
+func newTypingTracker() *typingTracker {
+	return &typingTracker{
+		active:  make(map[typingKey]*typingState),
+		members: make(map[int]cachedMembers),
+	}
+}
+
+// memberIDs returns the conversation's members, from cache when fresh
+func (t *typingTracker) memberIDs(conversationID int) ([]string, error) {
+	t.mu.Lock()
+	cached, ok := t.members[conversationID]
+	t.mu.Unlock()
+	if ok && time.Now().Before(cached.expiresAt) {
+		return cached.ids, nil
+	}
+
+	ids, err := model.GetConversationMemberIDs(conversationID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	t.mu.Lock()
+	if now.Sub(t.sweptAt) >= memberCacheTTL {
+		for id, m := range t.members {
+			if !now.Before(m.expiresAt) {
+				delete(t.members, id)
+			}
+		}
+		t.sweptAt = now
+	}
+	t.members[conversationID] = cachedMembers{ids: ids, expiresAt: now.Add(memberCacheTTL)}
+	t.mu.Unlock()
+	return ids, nil
+}
+
+// StartTyping marks the connection's user as typing in a conversation and
+// relays typing.start to the other members, at most once per
+// typingRelayInterval. The indicator stops on its own after typingTimeout.
+func (h *Hub) StartTyping(c *Connection, conversationID int) error {
+	memberIDs, err := h.typing.memberIDs(conversationID)
+	if err != nil {
+		return err
+	}
+	if !contains(memberIDs, c.UserID) {
+		return model.ErrNotConversationMember
+	}
+
+	key := typingKey{conversationID: conversationID, userID: c.UserID}
+	now := time.Now()
+
+	h.typing.mu.Lock()
+	s, ok := h.typing.active[key]
+	if !ok {
+		s = &typingState{}
+		s.timer = time.AfterFunc(typingTimeout, func() { h.expireTyping(key, s) })
+		h.typing.active[key] = s
+	} else {
+		s.timer.Reset(typingTimeout)
+	}
+	s.connID = c.ID
+	s.expiresAt = now.Add(typingTimeout)
+	relay := now.Sub(s.relayedAt) >= typingRelayInterval
+	if relay {
+		s.relayedAt = now
+	}
+	h.typing.mu.Unlock()
+
+	if relay {
+		h.relayTyping(EventTypingStart, key, memberIDs)
+	}
+	return nil
+}
+
+// StopTyping clears the user's indicator in a conversation and relays
+// typing.stop. Stopping when not typing does nothing.
+func (h *Hub) StopTyping(c *Connection, conversationID int) {
+	key := typingKey{conversationID: conversationID, userID: c.UserID}
+
+	h.typing.mu.Lock()
+	s, ok := h.typing.active[key]
+	if ok {
+		s.timer.Stop()
+		delete(h.typing.active, key)
+	}
+	h.typing.mu.Unlock()
+
+	if ok {
+		h.relayTypingStop(key)
+	}
+}
+
+// expireTyping stops an indicator whose timeout passed without a refresh
+func (h *Hub) expireTyping(key typingKey, s *typingState) {
+	h.typing.mu.Lock()
+	// The indicator may have been refreshed or replaced while the timer fired
+	if h.typing.active[key] != s || time.Now().Before(s.expiresAt) {
+		h.typing.mu.Unlock()
+		return
+	}
+	delete(h.typing.active, key)
+	h.typing.mu.Unlock()
+
+	h.relayTypingStop(key)
+}
+
+// clearTyping stops every indicator started from a connection that closed
+func (h *Hub) clearTyping(c *Connection) {
+	var stopped []typingKey
+
+	h.typing.mu.Lock()
+	for key, s := range h.typing.active {
+		if s.connID == c.ID {
+			s.timer.Stop()
+			delete(h.typing.active, key)
+			stopped = append(stopped, key)
+		}
+	}
+	h.typing.mu.Unlock()
+
+	for _, key := range stopped {
+		h.relayTypingStop(key)
+	}
+}
+
+func (h *Hub) relayTypingStop(key typingKey) {
+	memberIDs, err := h.typing.memberIDs(key.conversationID)
+	if err != nil {
+		log.Println("error loading conversation members:", err)
+		return
+	}
+	h.relayTyping(EventTypingStop, key, memberIDs)
+}
+
+// relayTyping sends a typing event to every member except the typist
+func (h *Hub) relayTyping(eventType string, key typingKey, memberIDs []string) {
+	data, err := EncodeEnvelope(eventType, "", TypingPayload{
+		ConversationID: key.conversationID,
+		UserID:         key.userID,
+	})
+	if err != nil {
+		return
+	}
+
+	for _, memberID := range memberIDs {
+		if memberID != key.userID {
+			h.SendMessage(memberID, data)
+		}
+	}
+}
+
+// rateLimiter is a token bucket. It is only used from a connection's read
+// goroutine, so it needs no locking.
+type rateLimiter struct {
+	rate, burst float64
+	tokens      float64
+	last        time.Time
+}
+
+func newRateLimiter(rate, burst float64) *rateLimiter {
+	return &rateLimiter{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// allow takes a token if one is available
+func (l *rateLimiter) allow() bool {
+	now := time.Now()
+	l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
+
+func contains(values []string, v string) bool {
+	for _, s := range values {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
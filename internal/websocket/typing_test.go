@@ -0,0 +1,164 @@
+package websocket
+
+import (
+	"encoding/json"
+	"fmt"
+	"messaging-service/internal/model"
+	"testing"
+	"time"
+)
+
+// newTypingHub returns a hub whose member cache knows the conversations, so
+// typing needs no database
+func newTypingHub(members map[int][]string) *Hub {
+	h := NewHub()
+	for id, ids := range members {
+		h.typing.members[id] = cachedMembers{ids: ids, expiresAt: time.Now().Add(time.Hour)}
+	}
+	return h
+}
+
+// connect adds a connection to the hub without the replay of Register
+func connect(h *Hub, userID, connID string) *Connection {
+	c := &Connection{ID: connID, UserID: userID, SendChan: make(chan []byte, 16)}
+	h.mu.Lock()
+	if h.connections[userID] == nil {
+		h.connections[userID] = make(map[string]*Connection)
+	}
+	h.connections[userID][connID] = c
+	h.mu.Unlock()
+	return c
+}
+
+// typingEvents returns the typing frames queued on a connection as
+// "<type> <user> <conversation>" strings
+func typingEvents(t *testing.T, c *Connection) []string {
+	t.Helper()
+	var events []string
+	for _, f := range drain(c) {
+		var env Envelope
+		var p TypingPayload
+		if err := json.Unmarshal([]byte(f), &env); err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(env.Payload, &p); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, fmt.Sprintf("%s %s %d", env.Type, p.UserID, p.ConversationID))
+	}
+	return events
+}
+
+func TestTypingRelayAndExpiry(t *testing.T) {
+	h := newTypingHub(map[int][]string{1: {"alice", "bob"}})
+	alice := connect(h, "alice", "a1")
+	bob := connect(h, "bob", "b1")
+
+	if err := h.StartTyping(connect(h, "eve", "e1"), 1); err != model.ErrNotConversationMember {
+		t.Fatalf("typing by a non-member: %v", err)
+	}
+
+	if err := h.StartTyping(alice, 1); err != nil {
+		t.Fatal(err)
+	}
+	// Repeats within the relay interval only refresh the indicator
+	if err := h.StartTyping(alice, 1); err != nil {
+		t.Fatal(err)
+	}
+	if got := typingEvents(t, bob); !equalFrames(got, "typing.start alice 1") {
+		t.Fatalf("bob got %v", got)
+	}
+	if got := drain(alice); len(got) != 0 {
+		t.Fatalf("the typist got %v", got)
+	}
+
+	key := typingKey{conversationID: 1, userID: "alice"}
+	h.typing.mu.Lock()
+	s := h.typing.active[key]
+	h.typing.mu.Unlock()
+
+	// A timer firing after a refresh leaves the indicator alone
+	h.expireTyping(key, s)
+	if got := typingEvents(t, bob); len(got) != 0 {
+		t.Fatalf("refreshed indicator expired: bob got %v", got)
+	}
+
+	h.typing.mu.Lock()
+	s.timer.Stop()
+	s.expiresAt = time.Now().Add(-time.Millisecond)
+	h.typing.mu.Unlock()
+	h.expireTyping(key, s)
+	if got := typingEvents(t, bob); !equalFrames(got, "typing.stop alice 1") {
+		t.Fatalf("on expiry bob got %v", got)
+	}
+	if _, ok := h.typing.active[key]; ok {
+		t.Fatal("expired indicator still active")
+	}
+
+	// Stopping relays once; stopping again does nothing
+	h.StartTyping(alice, 1)
+	h.StopTyping(alice, 1)
+	h.StopTyping(alice, 1)
+	if got := typingEvents(t, bob); !equalFrames(got, "typing.start alice 1", "typing.stop alice 1") {
+		t.Fatalf("start and stop: bob got %v", got)
+	}
+}
+
+func TestTypingStopsWhenConnectionCloses(t *testing.T) {
+	h := newTypingHub(map[int][]string{1: {"alice", "bob"}, 2: {"alice", "bob"}, 3: {"alice", "bob"}})
+	phone := connect(h, "alice", "a1")
+	laptop := connect(h, "alice", "a2")
+	bob := connect(h, "bob", "b1")
+
+	for _, id := range []int{1, 2} {
+		if err := h.StartTyping(phone, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := h.StartTyping(laptop, 3); err != nil {
+		t.Fatal(err)
+	}
+	drain(bob)
+
+	// Unregister clears the indicators of the closing connection first
+	h.clearTyping(phone)
+	got := typingEvents(t, bob)
+	if len(got) != 2 || !contains(got, "typing.stop alice 1") || !contains(got, "typing.stop alice 2") {
+		t.Fatalf("bob got %v, want stops for conversations 1 and 2", got)
+	}
+	if _, ok := h.typing.active[typingKey{conversationID: 3, userID: "alice"}]; !ok {
+		t.Fatal("the other device's indicator was cleared")
+	}
+	h.StopTyping(laptop, 3)
+}
+
+func TestRateLimiter(t *testing.T) {
+	l := newRateLimiter(typingRate, typingBurst)
+	for i := 0; i < int(typingBurst); i++ {
+		if !l.allow() {
+			t.Fatalf("event %d of the burst refused", i+1)
+		}
+	}
+	if l.allow() {
+		t.Fatal("event past the burst allowed")
+	}
+
+	// Tokens come back at typingRate per second, up to the burst
+	l.last = l.last.Add(-time.Second)
+	for i := 0; i < int(typingRate); i++ {
+		if !l.allow() {
+			t.Fatalf("refilled event %d refused", i+1)
+		}
+	}
+	if l.allow() {
+		t.Fatal("more events allowed than refilled")
+	}
+	l.last = l.last.Add(-time.Hour)
+	allowed := 0
+	for l.allow() {
+		allowed++
+	}
+	if allowed != int(typingBurst) {
+		t.Fatalf("after a long pause %d events allowed, want the burst of %d", allowed, int(typingBurst))
+	}
+}